@@ -1,16 +1,327 @@
+-- The file is idempotent and upgrades databases created by the original
+-- schema in place, see "Обновление схемы" in README.
+
+-- Adds the constraint unless the table already has one with that name.
+-- Lives in pg_temp, so it is gone at the end of the session.
+CREATE OR REPLACE FUNCTION pg_temp.add_constraint(tbl regclass, constraint_name text, definition text) RETURNS void AS $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = tbl AND conname = constraint_name) THEN
+        EXECUTE format('ALTER TABLE %s ADD CONSTRAINT %I %s', tbl, constraint_name, definition);
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Every row belongs to a tenant (environment or product vertical). Composite
+-- foreign keys keep memberships from crossing tenants.
 CREATE TABLE IF NOT EXISTS users (
-    id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    -- BY DEFAULT lets clients register users under ids assigned by the account service.
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY,
+    attributes JSONB NOT NULL DEFAULT '{}',
+    -- Bumped by every statement that changes the user's memberships, see
+    -- user_segments_bump_version. Clients send it back in If-Match.
+    version BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (tenant, id)
+);
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0,
+    ALTER COLUMN id TYPE BIGINT,
+    ALTER COLUMN id SET GENERATED BY DEFAULT;
+
+-- The original primary key was on id alone.
+DO $$
+BEGIN
+    IF (SELECT array_length(conkey, 1) FROM pg_constraint WHERE conrelid = 'users'::regclass AND contype = 'p') = 1 THEN
+        ALTER TABLE IF EXISTS user_segments DROP CONSTRAINT IF EXISTS user_segments_user_id_fkey;
+        ALTER TABLE users DROP CONSTRAINT users_pkey, ADD PRIMARY KEY (tenant, id);
+    END IF;
+END;
+$$;
+
+CREATE TABLE IF NOT EXISTS segment_groups (
+    id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    name VARCHAR(256) NOT NULL,
+    exclusive BOOLEAN NOT NULL DEFAULT true,
+    UNIQUE (tenant, name)
 );
 
 CREATE TABLE IF NOT EXISTS segments (
     id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
-    name VARCHAR(256) UNIQUE NOT NULL
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    name VARCHAR(256) NOT NULL,
+    -- "messenger" for "messenger/VOICE_MESSAGES", see access.Namespace.
+    namespace VARCHAR(256) GENERATED ALWAYS AS (
+        CASE WHEN position('/' IN name) > 1 THEN split_part(name, '/', 1) ELSE 'default' END
+    ) STORED,
+    rule TEXT,
+    group_id INTEGER REFERENCES segment_groups(id) ON DELETE SET NULL,
+    state VARCHAR(16) NOT NULL DEFAULT 'active' CHECK (state IN ('draft', 'active', 'paused', 'archived')),
+    starts_at TIMESTAMPTZ,
+    ends_at TIMESTAMPTZ,
+    archived_at TIMESTAMPTZ,
+    description TEXT NOT NULL DEFAULT '',
+    owner VARCHAR(256) NOT NULL DEFAULT '',
+    contact VARCHAR(256) NOT NULL DEFAULT '',
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- Segments built from other segments by a set operation. Live ones are
+    -- recomputed whenever memberships of their sources change.
+    composition_op VARCHAR(16) CHECK (composition_op IN ('union', 'intersection', 'difference')),
+    composition_sources INTEGER[],
+    composition_live BOOLEAN NOT NULL DEFAULT false,
+    CHECK (ends_at > starts_at),
+    UNIQUE (tenant, name),
+    UNIQUE (tenant, id)
+);
+
+ALTER TABLE segments
+    ADD COLUMN IF NOT EXISTS tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    ADD COLUMN IF NOT EXISTS namespace VARCHAR(256) GENERATED ALWAYS AS (
+        CASE WHEN position('/' IN name) > 1 THEN split_part(name, '/', 1) ELSE 'default' END
+    ) STORED,
+    ADD COLUMN IF NOT EXISTS rule TEXT,
+    ADD COLUMN IF NOT EXISTS group_id INTEGER,
+    ADD COLUMN IF NOT EXISTS state VARCHAR(16) NOT NULL DEFAULT 'active',
+    ADD COLUMN IF NOT EXISTS starts_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS ends_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS owner VARCHAR(256) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS contact VARCHAR(256) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS composition_op VARCHAR(16),
+    ADD COLUMN IF NOT EXISTS composition_sources INTEGER[],
+    ADD COLUMN IF NOT EXISTS composition_live BOOLEAN NOT NULL DEFAULT false,
+    DROP CONSTRAINT IF EXISTS segments_name_key;
+
+-- Names match the ones CREATE TABLE generates.
+SELECT pg_temp.add_constraint('segments', 'segments_group_id_fkey', 'FOREIGN KEY (group_id) REFERENCES segment_groups(id) ON DELETE SET NULL');
+SELECT pg_temp.add_constraint('segments', 'segments_state_check', $$CHECK (state IN ('draft', 'active', 'paused', 'archived'))$$);
+SELECT pg_temp.add_constraint('segments', 'segments_composition_op_check', $$CHECK (composition_op IN ('union', 'intersection', 'difference'))$$);
+SELECT pg_temp.add_constraint('segments', 'segments_check', 'CHECK (ends_at > starts_at)');
+SELECT pg_temp.add_constraint('segments', 'segments_tenant_name_key', 'UNIQUE (tenant, name)');
+SELECT pg_temp.add_constraint('segments', 'segments_tenant_id_key', 'UNIQUE (tenant, id)');
+
+CREATE INDEX IF NOT EXISTS segments_namespace_idx ON segments(tenant, namespace);
+CREATE INDEX IF NOT EXISTS segments_owner_idx ON segments(tenant, owner);
+CREATE INDEX IF NOT EXISTS segments_tags_idx ON segments USING GIN (tags);
+
+-- Former names of renamed segments, still accepted wherever a segment name is.
+CREATE TABLE IF NOT EXISTS segment_aliases (
+    id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    alias VARCHAR(256) NOT NULL,
+    segment_id INTEGER NOT NULL,
+    usage_count BIGINT NOT NULL DEFAULT 0,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (tenant, alias),
+    FOREIGN KEY (tenant, segment_id) REFERENCES segments(tenant, id) ON DELETE CASCADE
 );
 
+CREATE INDEX IF NOT EXISTS segment_aliases_segment_id_idx ON segment_aliases(segment_id);
+
 CREATE TABLE IF NOT EXISTS user_segments (
     id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
-    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
-    segment_id INTEGER REFERENCES segments(id) ON DELETE CASCADE,
-    UNIQUE(user_id, segment_id)
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    user_id BIGINT NOT NULL,
+    segment_id INTEGER NOT NULL,
+    -- Group of the segment when the group is exclusive and the segment is not
+    -- archived, NULL otherwise. The unique constraint keeps a user in at most
+    -- one live segment of such a group.
+    exclusive_group_id INTEGER REFERENCES segment_groups(id) ON DELETE SET NULL,
+    UNIQUE(tenant, user_id, segment_id),
+    CONSTRAINT user_segments_exclusive_group_key UNIQUE(tenant, user_id, exclusive_group_id),
+    FOREIGN KEY (tenant, user_id) REFERENCES users(tenant, id) ON DELETE CASCADE,
+    FOREIGN KEY (tenant, segment_id) REFERENCES segments(tenant, id) ON DELETE CASCADE
 );
 
+ALTER TABLE user_segments
+    ADD COLUMN IF NOT EXISTS tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    ADD COLUMN IF NOT EXISTS exclusive_group_id INTEGER,
+    ALTER COLUMN user_id TYPE BIGINT,
+    ALTER COLUMN user_id SET NOT NULL,
+    ALTER COLUMN segment_id SET NOT NULL,
+    DROP CONSTRAINT IF EXISTS user_segments_user_id_fkey,
+    DROP CONSTRAINT IF EXISTS user_segments_segment_id_fkey,
+    DROP CONSTRAINT IF EXISTS user_segments_user_id_segment_id_key;
+
+SELECT pg_temp.add_constraint('user_segments', 'user_segments_exclusive_group_id_fkey', 'FOREIGN KEY (exclusive_group_id) REFERENCES segment_groups(id) ON DELETE SET NULL');
+SELECT pg_temp.add_constraint('user_segments', 'user_segments_tenant_user_id_segment_id_key', 'UNIQUE (tenant, user_id, segment_id)');
+SELECT pg_temp.add_constraint('user_segments', 'user_segments_exclusive_group_key', 'UNIQUE (tenant, user_id, exclusive_group_id)');
+SELECT pg_temp.add_constraint('user_segments', 'user_segments_tenant_user_id_fkey', 'FOREIGN KEY (tenant, user_id) REFERENCES users(tenant, id) ON DELETE CASCADE');
+SELECT pg_temp.add_constraint('user_segments', 'user_segments_tenant_segment_id_fkey', 'FOREIGN KEY (tenant, segment_id) REFERENCES segments(tenant, id) ON DELETE CASCADE');
+
+CREATE INDEX IF NOT EXISTS user_segments_segment_id_idx ON user_segments(segment_id);
+
+CREATE OR REPLACE FUNCTION user_segments_set_exclusive_group() RETURNS trigger AS $$
+BEGIN
+    SELECT segment_groups.id INTO NEW.exclusive_group_id
+    FROM segments JOIN segment_groups ON segment_groups.id = segments.group_id AND segment_groups.exclusive
+    WHERE segments.id = NEW.segment_id AND segments.state <> 'archived';
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS user_segments_set_exclusive_group ON user_segments;
+CREATE TRIGGER user_segments_set_exclusive_group
+    BEFORE INSERT ON user_segments
+    FOR EACH ROW EXECUTE FUNCTION user_segments_set_exclusive_group();
+
+-- Archived segments release their memberships from the exclusive group, and
+-- restoring one fails with user_segments_exclusive_group_key when some of its
+-- users have joined another segment of the group meanwhile.
+CREATE OR REPLACE FUNCTION segments_refresh_exclusive_group() RETURNS trigger AS $$
+DECLARE
+    exclusive_group INTEGER;
+BEGIN
+    IF NEW.state <> 'archived' THEN
+        SELECT id INTO exclusive_group FROM segment_groups WHERE id = NEW.group_id AND exclusive;
+    END IF;
+    UPDATE user_segments SET exclusive_group_id = exclusive_group
+    WHERE segment_id = NEW.id AND exclusive_group_id IS DISTINCT FROM exclusive_group;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS segments_refresh_exclusive_group ON segments;
+CREATE TRIGGER segments_refresh_exclusive_group
+    AFTER UPDATE OF state ON segments
+    FOR EACH ROW WHEN ((OLD.state = 'archived') <> (NEW.state = 'archived'))
+    EXECUTE FUNCTION segments_refresh_exclusive_group();
+
+UPDATE user_segments SET exclusive_group_id = NULL
+FROM segments
+WHERE segments.id = user_segments.segment_id AND segments.state = 'archived'
+  AND user_segments.exclusive_group_id IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION user_segments_bump_version() RETURNS trigger AS $$
+BEGIN
+    UPDATE users SET version = users.version + 1
+    FROM (SELECT DISTINCT tenant, user_id FROM changed) AS changed_users
+    WHERE users.tenant = changed_users.tenant AND users.id = changed_users.user_id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Transition tables need a trigger per event.
+DROP TRIGGER IF EXISTS user_segments_bump_version_insert ON user_segments;
+CREATE TRIGGER user_segments_bump_version_insert
+    AFTER INSERT ON user_segments REFERENCING NEW TABLE AS changed
+    FOR EACH STATEMENT EXECUTE FUNCTION user_segments_bump_version();
+
+DROP TRIGGER IF EXISTS user_segments_bump_version_delete ON user_segments;
+CREATE TRIGGER user_segments_bump_version_delete
+    AFTER DELETE ON user_segments REFERENCING OLD TABLE AS changed
+    FOR EACH STATEMENT EXECUTE FUNCTION user_segments_bump_version();
+
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    actor VARCHAR(256) NOT NULL,
+    request_id VARCHAR(256) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    action VARCHAR(64) NOT NULL,
+    payload JSONB,
+    result VARCHAR(16) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log(tenant, created_at);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log(tenant, actor);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    client VARCHAR(256) NOT NULL,
+    key VARCHAR(256) NOT NULL,
+    request_hash VARCHAR(64) NOT NULL,
+    status INTEGER,
+    response BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (client, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys(expires_at);
+
+-- Membership change events, written in the same transaction as user_segments.
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    event_type VARCHAR(32) NOT NULL,
+    user_id BIGINT NOT NULL,
+    segment VARCHAR(256) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    dispatched_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_not_dispatched_idx ON outbox(id) WHERE dispatched_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_user_id_idx ON outbox(tenant, user_id, id);
+-- The outbox doubles as membership history for segment statistics.
+CREATE INDEX IF NOT EXISTS outbox_segment_created_at_idx ON outbox(tenant, segment, created_at);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (tenant, url)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    event_id BIGINT NOT NULL REFERENCES outbox(id) ON DELETE CASCADE,
+    webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending', -- pending, delivered, dead
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error TEXT NOT NULL DEFAULT '',
+    delivered_at TIMESTAMPTZ,
+    UNIQUE(event_id, webhook_id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+
+CREATE OR REPLACE VIEW webhook_dead_letters AS
+SELECT d.id, w.tenant, d.webhook_id, w.url, d.attempts, d.last_error,
+       o.id AS event_id, o.event_type, o.user_id, o.segment, o.created_at
+FROM webhook_deliveries d
+JOIN webhooks w ON w.id = d.webhook_id
+JOIN outbox o ON o.id = d.event_id
+WHERE d.status = 'dead';
+
+CREATE TABLE IF NOT EXISTS experiments (
+    id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    name VARCHAR(256) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (tenant, name)
+);
+
+CREATE TABLE IF NOT EXISTS experiment_variants (
+    id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    experiment_id INTEGER NOT NULL REFERENCES experiments(id) ON DELETE CASCADE,
+    name VARCHAR(256) NOT NULL,
+    weight INTEGER NOT NULL CHECK (weight > 0),
+    UNIQUE(experiment_id, name)
+);
+
+-- The first assignment of a user is kept, so variants stay sticky.
+CREATE TABLE IF NOT EXISTS experiment_assignments (
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    user_id BIGINT NOT NULL,
+    experiment_id INTEGER NOT NULL REFERENCES experiments(id) ON DELETE CASCADE,
+    variant_id INTEGER NOT NULL REFERENCES experiment_variants(id) ON DELETE CASCADE,
+    assigned_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (tenant, user_id, experiment_id),
+    FOREIGN KEY (tenant, user_id) REFERENCES users(tenant, id) ON DELETE CASCADE
+);
@@ -1,16 +1,52 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/config"
+	auditList "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/audit/list"
+	exportDataset "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/dataset/export"
+	importDataset "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/dataset/load"
+	deleteExperiment "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/experiment/delete"
+	saveExperiment "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/experiment/save"
 	addToUserSegment "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/addToUser"
+	listSegmentAliases "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/alias/list"
+	retireSegmentAlias "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/alias/retire"
+	cloneSegment "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/clone"
+	composeSegment "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/compose"
 	deleteSegment1 "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/delete"
+	deleteSegmentGroup "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/group/delete"
+	saveSegmentGroup "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/group/save"
+	listSegments "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/list"
+	overlapSegment "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/overlap"
+	purgeSegment "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/purge"
+	querySegment "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/query"
+	renameSegment "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/rename"
+	restoreSegment "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/restore"
 	saveSegment "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/save"
+	stateSegment "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/state"
+	statsSegment "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/stats"
+	updateSegment "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/segment/update"
+	attributesUser "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/user/attributes"
 	deleteUser "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/user/delete"
+	replaceUserSegments "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/user/replace"
 	saveUser "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/user/save"
 	getUserSegments "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/user/segments"
+	streamUserSegments "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/user/stream"
+	deadLettersWebhook "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/webhook/deadLetters"
+	deleteWebhook "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/webhook/delete"
+	redeliverWebhook "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/webhook/redeliver"
+	saveWebhook "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/handlers/webhook/save"
+	mwAuth "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/middleware/auth"
+	mwIdempotency "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/middleware/idempotency"
 	mwLogger "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/middleware/logger"
+	mwRateLimit "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/middleware/ratelimit"
+	mwTenant "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/http-server/middleware/tenant"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/pubsub"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/scheduler"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage/postgresql"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/webhook"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"golang.org/x/exp/slog"
@@ -26,30 +62,84 @@ func main() {
 	log.Info("starting service", slog.String("env", cfg.Env))
 	log.Debug("debug messages are enabled")
 
-	storage, err := postgresql.New(cfg.Storage)
+	broker := pubsub.New()
+
+	storage, err := postgresql.New(log, cfg.Storage, cfg.Tenants, broker)
 	if err != nil {
 		log.Error("failed to init storage", sl.Err(err))
 		os.Exit(1)
 	}
 	//log.Info("connect db", slog.String("env", cfg.Env))
 
+	dispatcher := webhook.NewDispatcher(log, cfg.Webhooks, storage)
+	go dispatcher.Run(context.Background())
+
+	go scheduler.New(log, cfg.Scheduler, storage).Run(context.Background())
+
 	router := chi.NewRouter()
 	router.Use(middleware.RequestID)
 	router.Use(middleware.Logger)
 	router.Use(mwLogger.New(log))
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.URLFormat)
+	router.Use(mwAuth.New(log, cfg.Auth))
+	// After auth, so that clients are told apart by principal rather than
+	// by a key nobody checked.
+	router.Use(mwRateLimit.New(log, cfg.RateLimit))
+	router.Use(mwTenant.New(log, cfg.Tenants))
+	router.Use(mwIdempotency.New(log, cfg.Idempotency, storage))
+
+	router.Get("/debug/vars", expvar.Handler().ServeHTTP)
 
 	router.Route("/user", func(r chi.Router) {
-		r.Post("/save", saveUser.New(log, storage))
-		r.Delete("/delete", deleteUser.New(log, storage))
+		r.Post("/save", saveUser.New(log, storage, storage))
+		r.Delete("/delete", deleteUser.New(log, storage, storage))
 		r.Get("/segments", getUserSegments.New(log, storage))
+		r.Put("/segments", replaceUserSegments.New(log, storage, storage))
+		r.Post("/attributes", attributesUser.New(log, storage, storage))
+		r.Get("/segments/stream", streamUserSegments.New(log, cfg.Stream, broker, storage))
 	})
 
 	router.Route("/segment", func(r chi.Router) {
-		r.Post("/save", saveSegment.New(log, storage))
-		r.Delete("/delete", deleteSegment1.New(log, storage))
-		r.Post("/addToUser", addToUserSegment.New(log, storage))
+		r.Post("/save", saveSegment.New(log, storage, storage))
+		r.Post("/compose", composeSegment.New(log, storage, storage))
+		r.Post("/clone", cloneSegment.New(log, storage, storage))
+		r.Delete("/delete", deleteSegment1.New(log, storage, storage))
+		r.Post("/restore", restoreSegment.New(log, storage, storage))
+		r.Delete("/purge", purgeSegment.New(log, storage, storage))
+		r.Post("/state", stateSegment.New(log, storage, storage))
+		r.Patch("/update", updateSegment.New(log, storage, storage))
+		r.Get("/list", listSegments.New(log, storage))
+		r.Get("/stats", statsSegment.New(log, storage))
+		r.Get("/overlap", overlapSegment.New(log, storage))
+		r.Get("/query", querySegment.New(log, storage))
+		r.Post("/rename", renameSegment.New(log, storage, storage))
+		r.Get("/alias/list", listSegmentAliases.New(log, storage))
+		r.Delete("/alias/retire", retireSegmentAlias.New(log, storage, storage))
+		r.Post("/addToUser", addToUserSegment.New(log, storage, storage))
+		r.Post("/group/save", saveSegmentGroup.New(log, storage, storage))
+		r.Delete("/group/delete", deleteSegmentGroup.New(log, storage, storage))
+	})
+
+	router.Route("/experiment", func(r chi.Router) {
+		r.Post("/save", saveExperiment.New(log, storage, storage))
+		r.Delete("/delete", deleteExperiment.New(log, storage, storage))
+	})
+
+	router.Route("/webhook", func(r chi.Router) {
+		r.Post("/save", saveWebhook.New(log, storage, storage))
+		r.Delete("/delete", deleteWebhook.New(log, storage, storage))
+		r.Get("/deadLetters", deadLettersWebhook.New(log, storage))
+		r.Post("/redeliver", redeliverWebhook.New(log, storage, storage))
+	})
+
+	router.Route("/dataset", func(r chi.Router) {
+		r.Get("/export", exportDataset.New(log, storage))
+		r.Post("/import", importDataset.New(log, storage, storage))
+	})
+
+	router.Route("/audit", func(r chi.Router) {
+		r.Get("/list", auditList.New(log, storage))
 	})
 
 	log.Info("starting server", slog.String("address", cfg.HTTPServer.Address))
@@ -8,9 +8,16 @@ import (
 )
 
 type Config struct {
-	Env        string `yaml:"env" env-default:"local"`
-	HTTPServer `yaml:"http_server"`
-	Storage    `yaml:"storage"`
+	Env         string `yaml:"env" env-default:"local"`
+	HTTPServer  `yaml:"http_server"`
+	Storage     `yaml:"storage"`
+	RateLimit   `yaml:"rate_limit"`
+	Idempotency `yaml:"idempotency"`
+	Webhooks    `yaml:"webhooks"`
+	Stream      `yaml:"stream"`
+	Scheduler   `yaml:"scheduler"`
+	Auth        `yaml:"auth"`
+	Tenants     `yaml:"tenants"`
 }
 
 type HTTPServer struct {
@@ -26,6 +33,71 @@ type Storage struct {
 	DB       string `yaml:"db" env-default:"segments"`
 	Password string `yaml:"password" env-default:"postgres"`
 	Sslmode  string `yaml:"sslmode" env-default:"disable"`
+	// AutoCreateUsers registers unknown users on their first membership change
+	// instead of failing with storage.ErrUserNotFound.
+	AutoCreateUsers bool `yaml:"auto_create_users" env-default:"false"`
+}
+
+type RateLimit struct {
+	Enabled bool             `yaml:"enabled" env-default:"false"`
+	Default Limit            `yaml:"default"`
+	Routes  map[string]Limit `yaml:"routes"`
+}
+
+type Limit struct {
+	RPS   float64 `yaml:"rps" env-default:"10"`
+	Burst int     `yaml:"burst" env-default:"20"`
+}
+
+type Idempotency struct {
+	TTL time.Duration `yaml:"ttl" env-default:"24h"`
+}
+
+type Webhooks struct {
+	Interval    time.Duration `yaml:"interval" env-default:"1s"`
+	BatchSize   int           `yaml:"batch_size" env-default:"100"`
+	Workers     int           `yaml:"workers" env-default:"8"`
+	Timeout     time.Duration `yaml:"timeout" env-default:"5s"`
+	MaxAttempts int           `yaml:"max_attempts" env-default:"10"`
+	BackoffBase time.Duration `yaml:"backoff_base" env-default:"1s"`
+	BackoffMax  time.Duration `yaml:"backoff_max" env-default:"1h"`
+}
+
+type Stream struct {
+	Heartbeat   time.Duration `yaml:"heartbeat" env-default:"15s"`
+	ReplayLimit int           `yaml:"replay_limit" env-default:"1000"`
+}
+
+type Scheduler struct {
+	Interval time.Duration `yaml:"interval" env-default:"1m"`
+}
+
+type Auth struct {
+	Enabled    bool        `yaml:"enabled" env-default:"false"`
+	Principals []Principal `yaml:"principals"`
+}
+
+// Principal is a caller identified by its API key. Namespaces lists the
+// segment namespaces it may change, "*" grants all of them.
+type Principal struct {
+	Name       string   `yaml:"name"`
+	APIKey     string   `yaml:"api_key"`
+	Namespaces []string `yaml:"namespaces"`
+	// Tenant pins the principal to one tenant, empty lets it pick any with
+	// the X-Tenant header.
+	Tenant string `yaml:"tenant"`
+}
+
+type Tenants struct {
+	Default string `yaml:"default" env-default:"default"`
+	// Quotas lists the known tenants besides Default. A zero limit means
+	// no limit.
+	Quotas map[string]Quota `yaml:"quotas"`
+}
+
+type Quota struct {
+	MaxUsers    int `yaml:"max_users"`
+	MaxSegments int `yaml:"max_segments"`
 }
 
 //func New() *Config {
@@ -0,0 +1,110 @@
+package list
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"time"
+)
+
+type Request struct {
+	Actor     string     `json:"actor,omitempty"`
+	Action    string     `json:"action,omitempty"`
+	RequestID string     `json:"request_id,omitempty"`
+	Result    string     `json:"result,omitempty" validate:"omitempty,oneof=OK Error"`
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+	Limit     int        `json:"limit,omitempty" validate:"gte=0,lte=1000"`
+	Offset    int        `json:"offset,omitempty" validate:"gte=0"`
+}
+
+type Response struct {
+	resp.Response
+	Entries []storage.AuditEntryDTO `json:"entries,omitempty"`
+}
+
+type AuditEntriesGetter interface {
+	GetAuditEntries(tenant string, filter storage.AuditFilterDTO) ([]storage.AuditEntryDTO, error)
+}
+
+func New(log *slog.Logger, auditEntriesGetter AuditEntriesGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.audit.list.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		// The audit log records requests of every namespace.
+		if p := access.FromContext(r.Context()); p != nil && !p.All() {
+			log.Info("access denied", slog.String("principal", p.Name))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("reading the audit log requires access to all namespaces"))
+
+			return
+		}
+
+		var req Request
+
+		// An empty body means no filters.
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		entries, err := auditEntriesGetter.GetAuditEntries(access.Tenant(r.Context()), storage.AuditFilterDTO{
+			Actor:     req.Actor,
+			Action:    req.Action,
+			RequestID: req.RequestID,
+			Result:    req.Result,
+			From:      req.From,
+			To:        req.To,
+			Limit:     req.Limit,
+			Offset:    req.Offset,
+		})
+		if err != nil {
+			log.Error("failed to get audit entries", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get audit entries"))
+
+			return
+		}
+
+		log.Info("get audit entries", slog.Int("count", len(entries)))
+
+		responseOK(w, r, entries)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, entries []storage.AuditEntryDTO) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Entries:  entries,
+	})
+}
@@ -0,0 +1,66 @@
+package export
+
+import (
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/dataset"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"time"
+)
+
+type DatasetExporter interface {
+	ExportDataset(tenant string) (*storage.DatasetDTO, error)
+}
+
+func New(log *slog.Logger, datasetExporter DatasetExporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.dataset.export.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		// The archive holds segments of every namespace.
+		if p := access.FromContext(r.Context()); p != nil && !p.All() {
+			log.Info("access denied", slog.String("principal", p.Name))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("export requires access to all namespaces"))
+
+			return
+		}
+
+		tenant := access.Tenant(r.Context())
+
+		d, err := datasetExporter.ExportDataset(tenant)
+		if err != nil {
+			log.Error("failed to export dataset", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to export dataset"))
+
+			return
+		}
+
+		filename := fmt.Sprintf("segments-%s-%s.ndjson", tenant, time.Now().UTC().Format("20060102T150405Z"))
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+		if err := dataset.Write(w, tenant, d); err != nil {
+			log.Error("failed to write archive", sl.Err(err))
+			return
+		}
+
+		log.Info("dataset exported",
+			slog.Int("segments", len(d.Segments)),
+			slog.Int("users", len(d.Users)),
+			slog.Int("memberships", len(d.Memberships)),
+		)
+	}
+}
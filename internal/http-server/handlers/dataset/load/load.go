@@ -0,0 +1,148 @@
+package load
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/dataset"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	modeMerge   = "merge"
+	modeReplace = "replace"
+)
+
+// Request describes an import for the audit log, the archive itself comes as
+// the request body and the mode in the query string.
+type Request struct {
+	Mode        string    `json:"mode"`
+	Source      string    `json:"source"`
+	ExportedAt  time.Time `json:"exported_at"`
+	Segments    int       `json:"segments"`
+	Users       int       `json:"users"`
+	Memberships int       `json:"memberships"`
+}
+
+type Response struct {
+	resp.Response
+	Mode        string `json:"mode,omitempty"`
+	Groups      int    `json:"groups"`
+	Segments    int    `json:"segments"`
+	Aliases     int    `json:"aliases"`
+	Users       int    `json:"users"`
+	Memberships int    `json:"memberships"`
+}
+
+type DatasetImporter interface {
+	ImportDataset(tenant string, dataset storage.DatasetDTO, replace bool) error
+}
+
+func New(log *slog.Logger, datasetImporter DatasetImporter, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.dataset.load.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = modeMerge
+		}
+		if mode != modeMerge && mode != modeReplace {
+			log.Error("invalid mode", slog.String("mode", mode))
+
+			render.JSON(w, r, resp.Error("field mode must be one of [merge replace]"))
+
+			return
+		}
+
+		// Replace may remove segments of every namespace.
+		if p := access.FromContext(r.Context()); p != nil && !p.All() {
+			log.Info("access denied", slog.String("principal", p.Name))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("import requires access to all namespaces"))
+
+			return
+		}
+
+		header, d, err := dataset.Read(r.Body)
+		if errors.Is(err, dataset.ErrInvalid) {
+			log.Info("invalid archive", sl.Err(err))
+
+			render.Status(r, http.StatusUnprocessableEntity)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to read archive", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to read archive"))
+
+			return
+		}
+
+		req := Request{
+			Mode:        mode,
+			Source:      header.Tenant,
+			ExportedAt:  header.ExportedAt,
+			Segments:    len(d.Segments),
+			Users:       len(d.Users),
+			Memberships: len(d.Memberships),
+		}
+		log.Info("archive decoded", slog.Any("request", req))
+
+		err = datasetImporter.ImportDataset(access.Tenant(r.Context()), *d, mode == modeReplace)
+		audit.Record(log, auditor, r, audit.ActionDatasetImport, req, err)
+		if errors.Is(err, storage.ErrSegmentExists) || errors.Is(err, storage.ErrGroupConflict) {
+			log.Info("archive conflicts with existing data", sl.Err(err))
+
+			render.Status(r, http.StatusConflict)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			log.Info("tenant quota exceeded", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("quota exceeded"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to import dataset", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to import dataset"))
+
+			return
+		}
+
+		log.Info("dataset imported", slog.String("mode", mode))
+
+		responseOK(w, r, mode, d)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, mode string, d *storage.DatasetDTO) {
+	render.JSON(w, r, Response{
+		Response:    resp.OK(),
+		Mode:        mode,
+		Groups:      len(d.Groups),
+		Segments:    len(d.Segments),
+		Aliases:     len(d.Aliases),
+		Users:       len(d.Users),
+		Memberships: len(d.Memberships),
+	})
+}
@@ -0,0 +1,108 @@
+package delete
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Request struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type Response struct {
+	resp.Response
+	Name string `json:"name,omitempty"`
+}
+
+type ExperimentDeleter interface {
+	DeleteExperiment(tenant string, name string) error
+}
+
+func New(log *slog.Logger, experimentDeleter ExperimentDeleter, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.experiment.delete.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		// Experiments are not scoped to a namespace.
+		if p := access.FromContext(r.Context()); p != nil && !p.All() {
+			log.Info("access denied", slog.String("principal", p.Name))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("managing experiments requires access to all namespaces"))
+
+			return
+		}
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		err = experimentDeleter.DeleteExperiment(access.Tenant(r.Context()), req.Name)
+		audit.Record(log, auditor, r, audit.ActionExperimentDelete, req, err)
+		if errors.Is(err, storage.ErrExperimentNotFound) {
+			log.Info("experiment not found", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("experiment not found"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to delete experiment", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to delete experiment"))
+
+			return
+		}
+
+		log.Info("experiment deleted", slog.String("name", req.Name))
+
+		responseOK(w, r, req.Name)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, name string) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Name:     name,
+	})
+}
@@ -0,0 +1,62 @@
+package delete
+
+import (
+	"context"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeDeleter struct {
+	called bool
+}
+
+func (s *fakeDeleter) DeleteExperiment(tenant string, name string) error {
+	s.called = true
+	return nil
+}
+
+type nopRecorder struct{}
+
+func (nopRecorder) SaveAuditEntry(entry storage.AuditEntryDTO) error {
+	return nil
+}
+
+func TestAccess(t *testing.T) {
+	tests := []struct {
+		name       string
+		principal  *access.Principal
+		wantStatus int
+	}{
+		{name: "no authentication", wantStatus: http.StatusOK},
+		{name: "all namespaces", principal: &access.Principal{Name: "admin", Namespaces: []string{access.AllNamespaces}}, wantStatus: http.StatusOK},
+		{name: "some namespaces", principal: &access.Principal{Name: "messenger", Namespaces: []string{"messenger", access.DefaultNamespace}}, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := slog.New(slog.NewTextHandler(io.Discard, nil))
+			deleter := &fakeDeleter{}
+			handler := New(log, deleter, nopRecorder{})
+
+			r := httptest.NewRequest(http.MethodDelete, "/experiment/delete", strings.NewReader(`{"name":"checkout"}`))
+			if tt.principal != nil {
+				r = r.WithContext(access.WithPrincipal(context.Background(), tt.principal))
+			}
+			w := httptest.NewRecorder()
+
+			handler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if wantCalled := tt.wantStatus == http.StatusOK; deleter.called != wantCalled {
+				t.Errorf("storage called = %v, want %v", deleter.called, wantCalled)
+			}
+		})
+	}
+}
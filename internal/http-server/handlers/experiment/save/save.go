@@ -0,0 +1,141 @@
+package save
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Variant struct {
+	Name   string `json:"name" validate:"required"`
+	Weight int    `json:"weight" validate:"required,gt=0"`
+}
+
+type Request struct {
+	Name     string    `json:"name" validate:"required"`
+	Variants []Variant `json:"variants" validate:"required,min=2,dive"`
+}
+
+type Response struct {
+	resp.Response
+	Id       int64     `json:"id,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Variants []Variant `json:"variants,omitempty"`
+}
+
+type ExperimentSaver interface {
+	SaveExperiment(tenant string, experiment storage.ExperimentDTO) (*storage.ExperimentDTO, error)
+}
+
+func New(log *slog.Logger, experimentSaver ExperimentSaver, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.experiment.save.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		// Experiments are not scoped to a namespace.
+		if p := access.FromContext(r.Context()); p != nil && !p.All() {
+			log.Info("access denied", slog.String("principal", p.Name))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("managing experiments requires access to all namespaces"))
+
+			return
+		}
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		experiment := storage.ExperimentDTO{Name: req.Name}
+		seen := make(map[string]struct{}, len(req.Variants))
+		for _, variant := range req.Variants {
+			if _, ok := seen[variant.Name]; ok {
+				log.Error("duplicate variant", slog.String("variant", variant.Name))
+
+				render.JSON(w, r, resp.Error("variant names must be unique"))
+
+				return
+			}
+			seen[variant.Name] = struct{}{}
+
+			experiment.Variants = append(experiment.Variants, storage.VariantDTO{
+				Name:   variant.Name,
+				Weight: variant.Weight,
+			})
+		}
+
+		saved, err := experimentSaver.SaveExperiment(access.Tenant(r.Context()), experiment)
+		audit.Record(log, auditor, r, audit.ActionExperimentSave, req, err)
+		if errors.Is(err, storage.ErrExperimentExists) {
+			log.Info("experiment already exists", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("experiment already exists"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to save experiment", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to save experiment"))
+
+			return
+		}
+
+		log.Info("experiment saved", slog.String("name", saved.Name))
+
+		responseOK(w, r, saved)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, experiment *storage.ExperimentDTO) {
+	variants := make([]Variant, 0, len(experiment.Variants))
+	for _, variant := range experiment.Variants {
+		variants = append(variants, Variant{Name: variant.Name, Weight: variant.Weight})
+	}
+
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Id:       experiment.ID,
+		Name:     experiment.Name,
+		Variants: variants,
+	})
+}
@@ -2,7 +2,10 @@ package addToUser
 
 import (
 	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/etag"
 	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
 	"github.com/go-chi/chi/v5/middleware"
@@ -17,25 +20,35 @@ type Request struct {
 	SegmentsToSave   []string `json:"SegmentsToSave" validate:"required"`
 	SegmentsToDelete []string `json:"SegmentsToDelete" validate:"required"`
 	UserID           int64    `json:"UserID" validate:"required"`
+	SwapExclusive    bool     `json:"SwapExclusive,omitempty"`
+	// DryRun returns the diff the request would make without applying it.
+	DryRun bool `json:"DryRun,omitempty"`
 }
 
 type Response struct {
 	resp.Response
-	UserId           int64    `json:"UserId ,omitempty"`
-	AddedSegments    []string `json:"AddedSegments,omitempty"`
-	NotAddedSegments []string `json:"NotAddedSegments,omitempty"`
-	DeletedSegments  []string `json:"DeletedSegments ,omitempty"`
+	UserId             int64    `json:"UserId ,omitempty"`
+	AddedSegments      []string `json:"AddedSegments,omitempty"`
+	NotAddedSegments   []string `json:"NotAddedSegments,omitempty"`
+	AlreadyInSegments  []string `json:"AlreadyInSegments,omitempty"`
+	MissingSegments    []string `json:"MissingSegments,omitempty"`
+	DeletedSegments    []string `json:"DeletedSegments ,omitempty"`
+	NotDeletedSegments []string `json:"NotDeletedSegments,omitempty"`
+	ReplacedSegments   []string `json:"ReplacedSegments,omitempty"`
+	Version            int64    `json:"Version"`
+	DryRun             bool     `json:"DryRun,omitempty"`
 }
 
 type UserToSegmentsAdder interface {
-	AddUserToSegments(segmentsToSave []string, segmentsToDelete []string, userId int64) (*storage.UserInSegmentDTO, error)
+	access.Resolver
+	AddUserToSegments(tenant string, segmentsToSave []string, segmentsToDelete []string, userId int64, opts storage.MembershipOptionsDTO) (*storage.UserInSegmentDTO, error)
 }
 
-func New(log *slog.Logger, userToSegmentsAdder UserToSegmentsAdder) http.HandlerFunc {
+func New(log *slog.Logger, userToSegmentsAdder UserToSegmentsAdder, auditor audit.Recorder) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.segment.addToUser.New"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
@@ -74,7 +87,77 @@ func New(log *slog.Logger, userToSegmentsAdder UserToSegmentsAdder) http.Handler
 		segmentsToDelete := req.SegmentsToDelete
 		userID := req.UserID
 
-		res, err := userToSegmentsAdder.AddUserToSegments(segmentsToSave, segmentsToDelete, userID)
+		touched := make([]string, 0, len(segmentsToSave)+len(segmentsToDelete))
+		touched = append(append(touched, segmentsToSave...), segmentsToDelete...)
+		// Aliases are checked against the segment they point to.
+		if err := access.CheckResolved(r.Context(), userToSegmentsAdder, touched...); err != nil {
+			var denied *access.DeniedError
+			if !errors.As(err, &denied) {
+				log.Error("failed to resolve segments", sl.Err(err))
+
+				render.JSON(w, r, resp.Error("failed to resolve segments"))
+
+				return
+			}
+
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		ifVersion, err := etag.IfMatch(r)
+		if err != nil {
+			log.Info("invalid If-Match", sl.Err(err))
+
+			render.Status(r, http.StatusPreconditionFailed)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		res, err := userToSegmentsAdder.AddUserToSegments(access.Tenant(r.Context()), segmentsToSave, segmentsToDelete, userID, storage.MembershipOptionsDTO{
+			SwapExclusive: req.SwapExclusive,
+			DryRun:        req.DryRun,
+			IfVersion:     ifVersion,
+		})
+		// A dry run changes nothing, so it is not an auditable action.
+		if !req.DryRun {
+			audit.Record(log, auditor, r, audit.ActionSegmentAddToUser, req, err)
+		}
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", slog.Int64("UserId", userID))
+
+			render.JSON(w, r, resp.Error("user not found"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			log.Info("user segments changed since If-Match version", sl.Err(err))
+
+			render.Status(r, http.StatusPreconditionFailed)
+			render.JSON(w, r, resp.Error("user segments changed, fetch them again"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			log.Info("tenant quota exceeded", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("quota exceeded"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrSegmentConflict) {
+			log.Info("exclusive segment conflict", sl.Err(err))
+
+			render.Status(r, http.StatusConflict)
+			render.JSON(w, r, resp.Error(conflictMessage(err)))
+
+			return
+		}
 		if err != nil {
 			log.Error("failed to change user segments", sl.Err(err))
 
@@ -82,18 +165,37 @@ func New(log *slog.Logger, userToSegmentsAdder UserToSegmentsAdder) http.Handler
 
 			return
 		}
-		log.Info("user segments changed", slog.Int64("UserId", res.UserID))
+		if req.DryRun {
+			log.Info("user segments change previewed", slog.Int64("UserId", res.UserID))
+		} else {
+			log.Info("user segments changed", slog.Int64("UserId", res.UserID))
+		}
 
-		responseOK(w, r, res)
+		responseOK(w, r, res, req.DryRun)
 	}
 }
 
-func responseOK(w http.ResponseWriter, r *http.Request, result *storage.UserInSegmentDTO) {
+func responseOK(w http.ResponseWriter, r *http.Request, result *storage.UserInSegmentDTO, dryRun bool) {
+	w.Header().Set(etag.HeaderETag, etag.Format(result.Version))
 	render.JSON(w, r, Response{
-		Response:         resp.OK(),
-		UserId:           result.UserID,
-		AddedSegments:    result.AddedSegments,
-		NotAddedSegments: result.NotAddedSegments,
-		DeletedSegments:  result.DeletedSegments,
+		Response:           resp.OK(),
+		UserId:             result.UserID,
+		AddedSegments:      result.AddedSegments,
+		NotAddedSegments:   result.NotAddedSegments,
+		AlreadyInSegments:  result.AlreadyInSegments,
+		MissingSegments:    result.MissingSegments,
+		DeletedSegments:    result.DeletedSegments,
+		NotDeletedSegments: result.NotDeletedSegments,
+		ReplacedSegments:   result.ReplacedSegments,
+		Version:            result.Version,
+		DryRun:             dryRun,
 	})
 }
+
+func conflictMessage(err error) string {
+	var conflictErr *storage.SegmentConflictError
+	if errors.As(err, &conflictErr) {
+		return conflictErr.Error()
+	}
+	return "segment conflicts with another segment of an exclusive group"
+}
@@ -0,0 +1,72 @@
+package list
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+// Request narrows the list down to one segment, an empty body lists all aliases.
+type Request struct {
+	Name string `json:"Name,omitempty"`
+}
+
+type Response struct {
+	resp.Response
+	Aliases []storage.SegmentAliasDTO `json:"aliases,omitempty"`
+}
+
+type SegmentAliasesGetter interface {
+	GetSegmentAliases(tenant string, segment string) ([]storage.SegmentAliasDTO, error)
+}
+
+func New(log *slog.Logger, segmentAliasesGetter SegmentAliasesGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.alias.list.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		aliases, err := segmentAliasesGetter.GetSegmentAliases(access.Tenant(r.Context()), req.Name)
+		if err != nil {
+			log.Error("failed to get segment aliases", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get segment aliases"))
+
+			return
+		}
+
+		log.Info("get segment aliases", slog.Int("count", len(aliases)))
+
+		responseOK(w, r, aliases)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, aliases []storage.SegmentAliasDTO) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Aliases:  aliases,
+	})
+}
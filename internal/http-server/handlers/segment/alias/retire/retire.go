@@ -0,0 +1,118 @@
+package retire
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Request struct {
+	Alias string `json:"alias" validate:"required"`
+}
+
+type Response struct {
+	resp.Response
+	Alias string `json:"alias,omitempty"`
+}
+
+type SegmentAliasRetirer interface {
+	access.Resolver
+	RetireSegmentAlias(tenant string, alias string) error
+}
+
+func New(log *slog.Logger, segmentAliasRetirer SegmentAliasRetirer, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.alias.retire.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		// The alias is checked against the segment it points to.
+		if err := access.CheckResolved(r.Context(), segmentAliasRetirer, req.Alias); err != nil {
+			var denied *access.DeniedError
+			if !errors.As(err, &denied) {
+				log.Error("failed to resolve segment alias", sl.Err(err))
+
+				render.JSON(w, r, resp.Error("failed to retire segment alias"))
+
+				return
+			}
+
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		err = segmentAliasRetirer.RetireSegmentAlias(access.Tenant(r.Context()), req.Alias)
+		audit.Record(log, auditor, r, audit.ActionAliasRetire, req, err)
+		if errors.Is(err, storage.ErrAliasNotFound) {
+			log.Info("segment alias not found", slog.String("alias", req.Alias))
+
+			render.JSON(w, r, resp.Error("segment alias not found"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to retire segment alias", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to retire segment alias"))
+
+			return
+		}
+
+		log.Info("segment alias retired", slog.String("alias", req.Alias))
+
+		responseOK(w, r, req.Alias)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, alias string) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Alias:    alias,
+	})
+}
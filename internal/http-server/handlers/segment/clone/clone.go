@@ -0,0 +1,148 @@
+package clone
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+// Request copies segment Name to NewName. Percent limits the copied
+// memberships to a random sample, all of them are copied when it is omitted.
+type Request struct {
+	Name    string `json:"Name" validate:"required"`
+	NewName string `json:"new_name" validate:"required,nefield=Name"`
+	Percent *int   `json:"percent,omitempty" validate:"omitempty,gte=1,lte=100"`
+}
+
+type Response struct {
+	resp.Response
+	Id      int64  `json:"id,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Members int64  `json:"members"`
+}
+
+type SegmentCloner interface {
+	access.Resolver
+	CloneSegment(tenant string, name string, newName string, percent int) (*storage.ClonedSegmentDTO, error)
+}
+
+func New(log *slog.Logger, segmentCloner SegmentCloner, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.clone.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		// Aliases are checked against the segment they point to.
+		if err := access.CheckResolved(r.Context(), segmentCloner, req.Name, req.NewName); err != nil {
+			var denied *access.DeniedError
+			if !errors.As(err, &denied) {
+				log.Error("failed to resolve segments", sl.Err(err))
+
+				render.JSON(w, r, resp.Error("failed to resolve segments"))
+
+				return
+			}
+
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		percent := 100
+		if req.Percent != nil {
+			percent = *req.Percent
+		}
+
+		segment, err := segmentCloner.CloneSegment(access.Tenant(r.Context()), req.Name, req.NewName, percent)
+		audit.Record(log, auditor, r, audit.ActionSegmentClone, req, err)
+		if errors.Is(err, storage.ErrSegmentNotFound) {
+			log.Info("segment not found", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("segment not found"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrSegmentExists) {
+			log.Info("segment name is taken", slog.String("name", req.NewName))
+
+			render.JSON(w, r, resp.Error("segment or alias with this name already exists"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			log.Info("tenant quota exceeded", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("quota exceeded"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to clone segment", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to clone segment"))
+
+			return
+		}
+
+		log.Info("segment cloned", slog.String("source", segment.Source), slog.String("name", segment.Name), slog.Int64("members", segment.Members))
+
+		responseOK(w, r, segment)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, segment *storage.ClonedSegmentDTO) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Id:       segment.ID,
+		Name:     segment.Name,
+		Source:   segment.Source,
+		Members:  segment.Members,
+	})
+}
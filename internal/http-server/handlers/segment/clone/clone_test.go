@@ -0,0 +1,78 @@
+package clone
+
+import (
+	"encoding/json"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeCloner struct {
+	percent int
+}
+
+func (c *fakeCloner) ResolveSegmentNames(tenant string, names []string) ([]string, error) {
+	return names, nil
+}
+
+func (c *fakeCloner) CloneSegment(tenant string, name string, newName string, percent int) (*storage.ClonedSegmentDTO, error) {
+	c.percent = percent
+	return &storage.ClonedSegmentDTO{SegmentDTO: storage.SegmentDTO{ID: 1, Name: newName}, Source: name}, nil
+}
+
+type nopRecorder struct{}
+
+func (nopRecorder) SaveAuditEntry(entry storage.AuditEntryDTO) error {
+	return nil
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantPercent int
+		wantError   bool
+	}{
+		{name: "omitted", body: `{"Name":"a","new_name":"b"}`, wantPercent: 100},
+		{name: "sample", body: `{"Name":"a","new_name":"b","percent":10}`, wantPercent: 10},
+		{name: "all", body: `{"Name":"a","new_name":"b","percent":100}`, wantPercent: 100},
+		{name: "zero", body: `{"Name":"a","new_name":"b","percent":0}`, wantError: true},
+		{name: "negative", body: `{"Name":"a","new_name":"b","percent":-1}`, wantError: true},
+		{name: "over 100", body: `{"Name":"a","new_name":"b","percent":101}`, wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloner := &fakeCloner{}
+			handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), cloner, nopRecorder{})
+
+			r := httptest.NewRequest(http.MethodPost, "/segment/clone", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			handler(w, r)
+
+			var res Response
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if tt.wantError {
+				if res.Error == "" {
+					t.Errorf("request succeeded, want a validation error")
+				}
+				if cloner.percent != 0 {
+					t.Errorf("segment cloned with percent %d, want no clone", cloner.percent)
+				}
+				return
+			}
+			if res.Error != "" {
+				t.Fatalf("error = %q", res.Error)
+			}
+			if cloner.percent != tt.wantPercent {
+				t.Errorf("percent = %d, want %d", cloner.percent, tt.wantPercent)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package compose
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+// Request creates segment Name from Sources. For difference the members of
+// the first source that are in none of the others are taken. A Live segment
+// follows membership changes of its sources and can not be changed directly.
+type Request struct {
+	Name    string   `json:"Name" validate:"required"`
+	Op      string   `json:"op" validate:"required,oneof=union intersection difference"`
+	Sources []string `json:"sources" validate:"min=2,max=20,dive,required"`
+	Live    bool     `json:"live,omitempty"`
+	State   string   `json:"state,omitempty" validate:"omitempty,oneof=draft active paused"`
+
+	Description string   `json:"description,omitempty"`
+	Owner       string   `json:"owner,omitempty"`
+	Contact     string   `json:"contact,omitempty"`
+	Tags        []string `json:"tags,omitempty" validate:"omitempty,dive,required"`
+}
+
+type Response struct {
+	resp.Response
+	Id      int64  `json:"id,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Members int64  `json:"members"`
+	Live    bool   `json:"live"`
+}
+
+type SegmentComposer interface {
+	access.Resolver
+	SaveComposedSegment(tenant string, segment storage.NewSegmentDTO, composition storage.SegmentCompositionDTO) (*storage.ComposedSegmentDTO, error)
+}
+
+func New(log *slog.Logger, segmentComposer SegmentComposer, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.compose.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		// Aliases are checked against the segment they point to.
+		if err := access.CheckResolved(r.Context(), segmentComposer, append([]string{req.Name}, req.Sources...)...); err != nil {
+			var denied *access.DeniedError
+			if !errors.As(err, &denied) {
+				log.Error("failed to resolve segments", sl.Err(err))
+
+				render.JSON(w, r, resp.Error("failed to resolve segments"))
+
+				return
+			}
+
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		segment, err := segmentComposer.SaveComposedSegment(access.Tenant(r.Context()), storage.NewSegmentDTO{
+			Name:        req.Name,
+			State:       req.State,
+			Description: req.Description,
+			Owner:       req.Owner,
+			Contact:     req.Contact,
+			Tags:        req.Tags,
+		}, storage.SegmentCompositionDTO{
+			Op:      req.Op,
+			Sources: req.Sources,
+			Live:    req.Live,
+		})
+		audit.Record(log, auditor, r, audit.ActionSegmentCompose, req, err)
+		if errors.Is(err, storage.ErrSegmentNotFound) {
+			log.Info("source segment not found", sl.Err(err))
+
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+		if errors.Is(err, storage.ErrSegmentRuleBased) {
+			log.Info("source segment is rule based", sl.Err(err))
+
+			render.Status(r, http.StatusUnprocessableEntity)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+		if errors.Is(err, storage.ErrSegmentExists) {
+			log.Info("segment name is taken", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("segment or alias with this name already exists"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			log.Info("tenant quota exceeded", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("quota exceeded"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to compose segment", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to compose segment"))
+
+			return
+		}
+
+		log.Info("segment composed", slog.String("name", segment.Name), slog.Int64("members", segment.Members))
+
+		responseOK(w, r, segment, req.Live)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, segment *storage.ComposedSegmentDTO, live bool) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Id:       segment.ID,
+		Name:     segment.Name,
+		Members:  segment.Members,
+		Live:     live,
+	})
+}
@@ -2,8 +2,11 @@ package delete
 
 import (
 	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
 	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"github.com/go-playground/validator/v10"
@@ -22,14 +25,14 @@ type Response struct {
 }
 
 type SegmentDeleter interface {
-	DeleteSegment(name string) error
+	DeleteSegment(tenant string, name string) error
 }
 
-func New(log *slog.Logger, segmentDeleter SegmentDeleter) http.HandlerFunc {
+func New(log *slog.Logger, segmentDeleter SegmentDeleter, auditor audit.Recorder) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.segment.delete.New"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
@@ -66,7 +69,24 @@ func New(log *slog.Logger, segmentDeleter SegmentDeleter) http.HandlerFunc {
 
 		reqName := req.Name
 
-		err = segmentDeleter.DeleteSegment(reqName)
+		if err := access.Check(r.Context(), req.Name); err != nil {
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		err = segmentDeleter.DeleteSegment(access.Tenant(r.Context()), reqName)
+		audit.Record(log, auditor, r, audit.ActionSegmentDelete, req, err)
+		if errors.Is(err, storage.ErrSegmentNotFound) {
+			log.Info("segment not found", slog.String("name", reqName))
+
+			render.JSON(w, r, resp.Error("segment not found"))
+
+			return
+		}
 		if err != nil {
 			log.Error("failed to delete segment", sl.Err(err))
 
@@ -75,7 +95,7 @@ func New(log *slog.Logger, segmentDeleter SegmentDeleter) http.HandlerFunc {
 			return
 		}
 
-		log.Info("segment deleted", slog.String("name", reqName))
+		log.Info("segment archived", slog.String("name", reqName))
 
 		responseOK(w, r, reqName)
 	}
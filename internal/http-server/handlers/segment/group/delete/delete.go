@@ -0,0 +1,126 @@
+package delete
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Request struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type Response struct {
+	resp.Response
+	Name string `json:"name,omitempty"`
+}
+
+type SegmentGroupDeleter interface {
+	GetSegmentGroupSegments(tenant string, name string) ([]string, error)
+	DeleteSegmentGroup(tenant string, name string) error
+}
+
+func New(log *slog.Logger, segmentGroupDeleter SegmentGroupDeleter, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.group.delete.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		tenant := access.Tenant(r.Context())
+
+		segments, err := segmentGroupDeleter.GetSegmentGroupSegments(tenant, req.Name)
+		if errors.Is(err, storage.ErrGroupNotFound) {
+			log.Info("segment group not found", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("segment group not found"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to get segments of the group", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to delete segment group"))
+
+			return
+		}
+
+		if err := access.Check(r.Context(), segments...); err != nil {
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		err = segmentGroupDeleter.DeleteSegmentGroup(tenant, req.Name)
+		audit.Record(log, auditor, r, audit.ActionGroupDelete, req, err)
+		if errors.Is(err, storage.ErrGroupNotFound) {
+			log.Info("segment group not found", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("segment group not found"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to delete segment group", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to delete segment group"))
+
+			return
+		}
+
+		log.Info("segment group deleted", slog.String("name", req.Name))
+
+		responseOK(w, r, req.Name)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, name string) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Name:     name,
+	})
+}
@@ -0,0 +1,157 @@
+package save
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Request struct {
+	Name      string   `json:"name" validate:"required"`
+	Exclusive *bool    `json:"exclusive,omitempty"`
+	Segments  []string `json:"segments" validate:"required,dive,required"`
+}
+
+type Response struct {
+	resp.Response
+	Id        int64    `json:"id,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	Exclusive bool     `json:"exclusive"`
+	Segments  []string `json:"segments,omitempty"`
+}
+
+type SegmentGroupSaver interface {
+	access.Resolver
+	GetSegmentGroupSegments(tenant string, name string) ([]string, error)
+	SaveSegmentGroup(tenant string, group storage.SegmentGroupDTO) (*storage.SegmentGroupDTO, error)
+}
+
+func New(log *slog.Logger, segmentGroupSaver SegmentGroupSaver, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.group.save.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		// Groups are exclusive unless stated otherwise.
+		exclusive := true
+		if req.Exclusive != nil {
+			exclusive = *req.Exclusive
+		}
+
+		tenant := access.Tenant(r.Context())
+
+		// Current segments of the group leave it unless they are listed again.
+		current, err := segmentGroupSaver.GetSegmentGroupSegments(tenant, req.Name)
+		if err != nil && !errors.Is(err, storage.ErrGroupNotFound) {
+			log.Error("failed to get segments of the group", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to save segment group"))
+
+			return
+		}
+
+		// Aliases are checked against the segment they point to.
+		if err := access.CheckResolved(r.Context(), segmentGroupSaver, append(current, req.Segments...)...); err != nil {
+			var denied *access.DeniedError
+			if !errors.As(err, &denied) {
+				log.Error("failed to resolve segments", sl.Err(err))
+
+				render.JSON(w, r, resp.Error("failed to resolve segments"))
+
+				return
+			}
+
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		group, err := segmentGroupSaver.SaveSegmentGroup(tenant, storage.SegmentGroupDTO{
+			Name:      req.Name,
+			Exclusive: exclusive,
+			Segments:  req.Segments,
+		})
+		audit.Record(log, auditor, r, audit.ActionGroupSave, req, err)
+		if errors.Is(err, storage.ErrSegmentNotFound) {
+			log.Info("segment not found", sl.Err(err))
+
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+		if errors.Is(err, storage.ErrGroupConflict) {
+			log.Info("group conflicts with existing memberships", slog.String("name", req.Name))
+
+			render.Status(r, http.StatusConflict)
+			render.JSON(w, r, resp.Error("some users already have several segments of the group"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to save segment group", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to save segment group"))
+
+			return
+		}
+
+		log.Info("segment group saved", slog.String("name", group.Name))
+
+		responseOK(w, r, group)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, group *storage.SegmentGroupDTO) {
+	render.JSON(w, r, Response{
+		Response:  resp.OK(),
+		Id:        group.ID,
+		Name:      group.Name,
+		Exclusive: group.Exclusive,
+		Segments:  group.Segments,
+	})
+}
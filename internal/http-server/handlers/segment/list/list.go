@@ -0,0 +1,105 @@
+package list
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Request struct {
+	Namespace string   `json:"namespace,omitempty"`
+	Owner     string   `json:"owner,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	State     string   `json:"state,omitempty" validate:"omitempty,oneof=draft active paused archived"`
+	Query     string   `json:"query,omitempty"`
+	Limit     int      `json:"limit,omitempty" validate:"gte=0,lte=1000"`
+	Offset    int      `json:"offset,omitempty" validate:"gte=0"`
+}
+
+type Response struct {
+	resp.Response
+	Segments []storage.SegmentInfoDTO `json:"segments,omitempty"`
+}
+
+type SegmentsGetter interface {
+	GetSegments(tenant string, filter storage.SegmentFilterDTO) ([]storage.SegmentInfoDTO, error)
+}
+
+func New(log *slog.Logger, segmentsGetter SegmentsGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.list.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		// An empty body means no filters.
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		filter := storage.SegmentFilterDTO{
+			Namespace: req.Namespace,
+			Owner:     req.Owner,
+			Tags:      req.Tags,
+			State:     req.State,
+			Query:     req.Query,
+			Limit:     req.Limit,
+			Offset:    req.Offset,
+		}
+		if p := access.FromContext(r.Context()); p != nil && !p.All() {
+			filter.Namespaces = p.Namespaces
+			if filter.Namespaces == nil {
+				filter.Namespaces = []string{}
+			}
+		}
+
+		segments, err := segmentsGetter.GetSegments(access.Tenant(r.Context()), filter)
+		if err != nil {
+			log.Error("failed to get segments", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get segments"))
+
+			return
+		}
+
+		log.Info("get segments", slog.Int("count", len(segments)))
+
+		responseOK(w, r, segments)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, segments []storage.SegmentInfoDTO) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Segments: segments,
+	})
+}
@@ -0,0 +1,124 @@
+package overlap
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Request struct {
+	Segments []string `json:"segments" validate:"min=2,max=20,unique,dive,required"`
+}
+
+type Response struct {
+	resp.Response
+	Overlap *storage.SegmentOverlapDTO `json:"overlap,omitempty"`
+}
+
+type SegmentOverlapGetter interface {
+	access.Resolver
+	GetSegmentOverlap(tenant string, names []string) (*storage.SegmentOverlapDTO, error)
+}
+
+func New(log *slog.Logger, segmentOverlapGetter SegmentOverlapGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.overlap.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		// Aliases are checked against the segment they point to.
+		if err := access.CheckResolved(r.Context(), segmentOverlapGetter, req.Segments...); err != nil {
+			var denied *access.DeniedError
+			if !errors.As(err, &denied) {
+				log.Error("failed to resolve segments", sl.Err(err))
+
+				render.JSON(w, r, resp.Error("failed to resolve segments"))
+
+				return
+			}
+
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		overlap, err := segmentOverlapGetter.GetSegmentOverlap(access.Tenant(r.Context()), req.Segments)
+		if errors.Is(err, storage.ErrSegmentNotFound) {
+			log.Info("segment not found", sl.Err(err))
+
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+		if errors.Is(err, storage.ErrSegmentRuleBased) {
+			log.Info("overlap of a rule segment", sl.Err(err))
+
+			render.Status(r, http.StatusUnprocessableEntity)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to get segment overlap", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get segment overlap"))
+
+			return
+		}
+
+		log.Info("get segment overlap", slog.Any("segments", overlap.Segments))
+
+		responseOK(w, r, overlap)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, overlap *storage.SegmentOverlapDTO) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Overlap:  overlap,
+	})
+}
@@ -0,0 +1,67 @@
+package overlap
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeOverlapGetter treats the segments listed in rules as rule segments, the
+// way the storage does.
+type fakeOverlapGetter struct {
+	rules map[string]bool
+}
+
+func (g *fakeOverlapGetter) ResolveSegmentNames(tenant string, names []string) ([]string, error) {
+	return names, nil
+}
+
+func (g *fakeOverlapGetter) GetSegmentOverlap(tenant string, names []string) (*storage.SegmentOverlapDTO, error) {
+	for _, name := range names {
+		if g.rules[name] {
+			return nil, fmt.Errorf("%w: %s", storage.ErrSegmentRuleBased, name)
+		}
+	}
+	return &storage.SegmentOverlapDTO{Segments: names}, nil
+}
+
+func TestOverlap(t *testing.T) {
+	getter := &fakeOverlapGetter{rules: map[string]bool{"MOSCOW_IOS": true}}
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), getter)
+
+	tests := []struct {
+		name       string
+		segments   []string
+		wantStatus int
+		wantError  string
+	}{
+		{name: "explicit segments", segments: []string{"VOICE_MESSAGES", "DISCOUNT_50"}, wantStatus: http.StatusOK},
+		{name: "rule segment", segments: []string{"VOICE_MESSAGES", "MOSCOW_IOS"}, wantStatus: http.StatusUnprocessableEntity, wantError: "MOSCOW_IOS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(Request{Segments: tt.segments})
+			r := httptest.NewRequest(http.MethodGet, "/segment/overlap", strings.NewReader(string(body)))
+			w := httptest.NewRecorder()
+
+			handler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			var res Response
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if !strings.Contains(res.Error, tt.wantError) {
+				t.Errorf("error = %q, want it to mention %q", res.Error, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package purge
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+// Request must repeat the segment name in Confirm, purging is irreversible.
+type Request struct {
+	Name    string `json:"Name" validate:"required"`
+	Confirm string `json:"confirm" validate:"required"`
+}
+
+type Response struct {
+	resp.Response
+	Name string `json:"name,omitempty"`
+}
+
+type SegmentPurger interface {
+	PurgeSegment(tenant string, name string) error
+}
+
+func New(log *slog.Logger, segmentPurger SegmentPurger, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.purge.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		if req.Confirm != req.Name {
+			log.Error("purge is not confirmed", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("field confirm must repeat the segment name"))
+
+			return
+		}
+
+		if err := access.Check(r.Context(), req.Name); err != nil {
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		err = segmentPurger.PurgeSegment(access.Tenant(r.Context()), req.Name)
+		audit.Record(log, auditor, r, audit.ActionSegmentPurge, req, err)
+		if errors.Is(err, storage.ErrSegmentNotFound) {
+			log.Info("segment not found", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("segment not found"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrSegmentNotArchived) {
+			log.Info("segment is not archived", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("only archived segments can be purged"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to purge segment", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to purge segment"))
+
+			return
+		}
+
+		log.Info("segment purged", slog.String("name", req.Name))
+
+		responseOK(w, r, req.Name)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, name string) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Name:     name,
+	})
+}
@@ -0,0 +1,139 @@
+package query
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audience"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Request struct {
+	Query     string `json:"query" validate:"required,max=4096"`
+	CountOnly bool   `json:"count_only,omitempty"`
+	Limit     int    `json:"limit,omitempty" validate:"gte=0,lte=1000"`
+	Offset    int    `json:"offset,omitempty" validate:"gte=0"`
+}
+
+type Response struct {
+	resp.Response
+	Count int64   `json:"count"`
+	Users []int64 `json:"users,omitempty"`
+}
+
+type AudienceQuerier interface {
+	access.Resolver
+	QueryAudience(tenant string, expr audience.Expr, countOnly bool, limit int, offset int) (*storage.AudienceDTO, error)
+}
+
+func New(log *slog.Logger, audienceQuerier AudienceQuerier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.query.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		expr, err := audience.Parse(req.Query)
+		if err != nil {
+			log.Error("invalid query", sl.Err(err))
+
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		// Aliases are checked against the segment they point to.
+		if err := access.CheckResolved(r.Context(), audienceQuerier, audience.Segments(expr)...); err != nil {
+			var denied *access.DeniedError
+			if !errors.As(err, &denied) {
+				log.Error("failed to resolve segments", sl.Err(err))
+
+				render.JSON(w, r, resp.Error("failed to resolve segments"))
+
+				return
+			}
+
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		res, err := audienceQuerier.QueryAudience(access.Tenant(r.Context()), expr, req.CountOnly, req.Limit, req.Offset)
+		if errors.Is(err, storage.ErrSegmentNotFound) {
+			log.Info("segment not found", sl.Err(err))
+
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+		if errors.Is(err, storage.ErrSegmentRuleBased) {
+			log.Info("query refers to a rule segment", sl.Err(err))
+
+			render.Status(r, http.StatusUnprocessableEntity)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to query audience", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to query audience"))
+
+			return
+		}
+
+		log.Info("audience queried", slog.String("query", expr.String()), slog.Int64("count", res.Count))
+
+		responseOK(w, r, res)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, res *storage.AudienceDTO) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Count:    res.Count,
+		Users:    res.Users,
+	})
+}
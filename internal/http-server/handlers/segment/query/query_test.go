@@ -0,0 +1,76 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audience"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeQuerier treats the segments listed in rules as rule segments, the way
+// the storage does.
+type fakeQuerier struct {
+	rules map[string]bool
+}
+
+func (q *fakeQuerier) ResolveSegmentNames(tenant string, names []string) ([]string, error) {
+	return names, nil
+}
+
+func (q *fakeQuerier) QueryAudience(tenant string, expr audience.Expr, countOnly bool, limit int, offset int) (*storage.AudienceDTO, error) {
+	for _, name := range audience.Segments(expr) {
+		if q.rules[name] {
+			return nil, fmt.Errorf("%w: %s", storage.ErrSegmentRuleBased, name)
+		}
+	}
+	return &storage.AudienceDTO{Count: 2, Users: []int64{1, 2}}, nil
+}
+
+func TestQuery(t *testing.T) {
+	querier := &fakeQuerier{rules: map[string]bool{"MOSCOW_IOS": true}}
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), querier)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantError  string
+		wantCount  int64
+	}{
+		{name: "explicit segments", query: "VOICE_MESSAGES and not DISCOUNT_50", wantStatus: http.StatusOK, wantCount: 2},
+		{name: "rule segment", query: "VOICE_MESSAGES or MOSCOW_IOS", wantStatus: http.StatusUnprocessableEntity, wantError: "MOSCOW_IOS"},
+		{name: "rule segment negated", query: "not MOSCOW_IOS", wantStatus: http.StatusUnprocessableEntity, wantError: "MOSCOW_IOS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(Request{Query: tt.query})
+			r := httptest.NewRequest(http.MethodGet, "/segment/query", strings.NewReader(string(body)))
+			w := httptest.NewRecorder()
+
+			handler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			var res Response
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if tt.wantError != "" {
+				if !strings.Contains(res.Error, tt.wantError) {
+					t.Errorf("error = %q, want it to mention %s", res.Error, tt.wantError)
+				}
+				return
+			}
+			if res.Count != tt.wantCount {
+				t.Errorf("count = %d, want %d", res.Count, tt.wantCount)
+			}
+		})
+	}
+}
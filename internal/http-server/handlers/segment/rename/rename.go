@@ -0,0 +1,117 @@
+package rename
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Request struct {
+	Name    string `json:"Name" validate:"required"`
+	NewName string `json:"new_name" validate:"required,nefield=Name"`
+}
+
+type Response struct {
+	resp.Response
+	Name  string `json:"name,omitempty"`
+	Alias string `json:"alias,omitempty"`
+}
+
+type SegmentRenamer interface {
+	RenameSegment(tenant string, name string, newName string) error
+}
+
+func New(log *slog.Logger, segmentRenamer SegmentRenamer, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.rename.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		if err := access.Check(r.Context(), req.Name, req.NewName); err != nil {
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		err = segmentRenamer.RenameSegment(access.Tenant(r.Context()), req.Name, req.NewName)
+		audit.Record(log, auditor, r, audit.ActionSegmentRename, req, err)
+		if errors.Is(err, storage.ErrSegmentNotFound) {
+			log.Info("segment not found", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("segment not found"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrSegmentExists) {
+			log.Info("segment name is taken", slog.String("name", req.NewName))
+
+			render.JSON(w, r, resp.Error("segment or alias with this name already exists"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to rename segment", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to rename segment"))
+
+			return
+		}
+
+		log.Info("segment renamed", slog.String("name", req.Name), slog.String("new_name", req.NewName))
+
+		responseOK(w, r, req)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, req Request) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Name:     req.NewName,
+		Alias:    req.Name,
+	})
+}
@@ -0,0 +1,122 @@
+package restore
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Request struct {
+	Name string `json:"Name" validate:"required"`
+}
+
+type Response struct {
+	resp.Response
+	Name string `json:"name,omitempty"`
+}
+
+type SegmentRestorer interface {
+	RestoreSegment(tenant string, name string) error
+}
+
+func New(log *slog.Logger, segmentRestorer SegmentRestorer, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.restore.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		if err := access.Check(r.Context(), req.Name); err != nil {
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		err = segmentRestorer.RestoreSegment(access.Tenant(r.Context()), req.Name)
+		audit.Record(log, auditor, r, audit.ActionSegmentRestore, req, err)
+		if errors.Is(err, storage.ErrSegmentNotFound) {
+			log.Info("segment not found", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("segment not found"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrSegmentNotArchived) {
+			log.Info("segment is not archived", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("segment is not archived"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrGroupConflict) {
+			log.Info("segment conflicts with memberships of its group", slog.String("name", req.Name))
+
+			render.Status(r, http.StatusConflict)
+			render.JSON(w, r, resp.Error("some users already have another segment of the group"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to restore segment", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to restore segment"))
+
+			return
+		}
+
+		log.Info("segment restored", slog.String("name", req.Name))
+
+		responseOK(w, r, req.Name)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, name string) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Name:     name,
+	})
+}
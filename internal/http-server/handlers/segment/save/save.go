@@ -2,8 +2,11 @@ package save
 
 import (
 	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
 	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/rule"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
@@ -11,27 +14,41 @@ import (
 	"golang.org/x/exp/slog"
 	"io"
 	"net/http"
+	"time"
 )
 
 type Request struct {
-	Name string `json:"Name" validate:"required"`
+	Name     string     `json:"Name" validate:"required"`
+	Rule     string     `json:"rule,omitempty"`
+	State    string     `json:"state,omitempty" validate:"omitempty,oneof=draft active paused"`
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+
+	Description string   `json:"description,omitempty"`
+	Owner       string   `json:"owner,omitempty"`
+	Contact     string   `json:"contact,omitempty"`
+	Tags        []string `json:"tags,omitempty" validate:"omitempty,dive,required"`
 }
 
 type Response struct {
 	resp.Response
-	Id   int64  `json:"id,omitempty"`
-	Name string `json:"name,omitempty"`
+	Id       int64      `json:"id,omitempty"`
+	Name     string     `json:"name,omitempty"`
+	Rule     string     `json:"rule,omitempty"`
+	State    string     `json:"state,omitempty"`
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
 }
 
 type SegmentSaver interface {
-	SaveSegment(name string) (*storage.SegmentDTO, error)
+	SaveSegment(tenant string, segment storage.NewSegmentDTO) (*storage.SegmentDTO, error)
 }
 
-func New(log *slog.Logger, segmentSaver SegmentSaver) http.HandlerFunc {
+func New(log *slog.Logger, segmentSaver SegmentSaver, auditor audit.Recorder) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.segment.save.New"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
@@ -66,9 +83,56 @@ func New(log *slog.Logger, segmentSaver SegmentSaver) http.HandlerFunc {
 			return
 		}
 
+		if req.Rule != "" {
+			if _, err := rule.Parse(req.Rule); err != nil {
+				log.Error("invalid rule", sl.Err(err))
+
+				render.JSON(w, r, resp.Error(err.Error()))
+
+				return
+			}
+		}
+
+		if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+			log.Error("invalid activation window")
+
+			render.JSON(w, r, resp.Error("field ends_at must be after starts_at"))
+
+			return
+		}
+
 		reqName := req.Name
 
-		segment, err := segmentSaver.SaveSegment(reqName)
+		if err := access.Check(r.Context(), req.Name); err != nil {
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		segment, err := segmentSaver.SaveSegment(access.Tenant(r.Context()), storage.NewSegmentDTO{
+			Name:     reqName,
+			Rule:     req.Rule,
+			State:    req.State,
+			StartsAt: req.StartsAt,
+			EndsAt:   req.EndsAt,
+
+			Description: req.Description,
+			Owner:       req.Owner,
+			Contact:     req.Contact,
+			Tags:        req.Tags,
+		})
+		audit.Record(log, auditor, r, audit.ActionSegmentSave, req, err)
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			log.Info("tenant quota exceeded", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("quota exceeded"))
+
+			return
+		}
 		if err != nil {
 			log.Error("failed to save segment", sl.Err(err))
 
@@ -79,14 +143,23 @@ func New(log *slog.Logger, segmentSaver SegmentSaver) http.HandlerFunc {
 
 		log.Info("segment saved", slog.String("name", reqName))
 
-		responseOK(w, r, segment)
+		responseOK(w, r, segment, req)
 	}
 }
 
-func responseOK(w http.ResponseWriter, r *http.Request, segment *storage.SegmentDTO) {
+func responseOK(w http.ResponseWriter, r *http.Request, segment *storage.SegmentDTO, req Request) {
+	state := req.State
+	if state == "" {
+		state = storage.SegmentStateActive
+	}
+
 	render.JSON(w, r, Response{
 		Response: resp.OK(),
 		Id:       segment.ID,
 		Name:     segment.Name,
+		Rule:     req.Rule,
+		State:    state,
+		StartsAt: req.StartsAt,
+		EndsAt:   req.EndsAt,
 	})
 }
@@ -0,0 +1,117 @@
+package state
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Request struct {
+	Name  string `json:"Name" validate:"required"`
+	State string `json:"state" validate:"required,oneof=draft active paused"`
+}
+
+type Response struct {
+	resp.Response
+	Name  string `json:"name,omitempty"`
+	State string `json:"state,omitempty"`
+}
+
+type SegmentStateSetter interface {
+	SetSegmentState(tenant string, name string, state string) error
+}
+
+func New(log *slog.Logger, segmentStateSetter SegmentStateSetter, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.state.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		if err := access.Check(r.Context(), req.Name); err != nil {
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		err = segmentStateSetter.SetSegmentState(access.Tenant(r.Context()), req.Name, req.State)
+		audit.Record(log, auditor, r, audit.ActionSegmentState, req, err)
+		if errors.Is(err, storage.ErrSegmentNotFound) {
+			log.Info("segment not found", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("segment not found"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrSegmentArchived) {
+			log.Info("segment is archived", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("segment is archived, restore it first"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to change segment state", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to change segment state"))
+
+			return
+		}
+
+		log.Info("segment state changed", slog.String("name", req.Name), slog.String("state", req.State))
+
+		responseOK(w, r, req)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, req Request) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Name:     req.Name,
+		State:    req.State,
+	})
+}
@@ -0,0 +1,134 @@
+package stats
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultPeriod = 30 * 24 * time.Hour
+	maxPeriod     = 366 * 24 * time.Hour
+)
+
+// Request selects segments, all of them by default, and the period of the
+// daily history, the last 30 days by default.
+type Request struct {
+	Segments []string   `json:"segments,omitempty" validate:"dive,required"`
+	From     *time.Time `json:"from,omitempty"`
+	To       *time.Time `json:"to,omitempty"`
+}
+
+type Response struct {
+	resp.Response
+	Stats *storage.SegmentStatsDTO `json:"stats,omitempty"`
+}
+
+type SegmentStatsGetter interface {
+	GetSegmentStats(tenant string, filter storage.SegmentStatsFilterDTO) (*storage.SegmentStatsDTO, error)
+}
+
+func New(log *slog.Logger, segmentStatsGetter SegmentStatsGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.stats.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		// An empty body means all segments over the default period.
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		filter := storage.SegmentStatsFilterDTO{
+			Segments: req.Segments,
+			To:       time.Now(),
+		}
+		if req.To != nil {
+			filter.To = *req.To
+		}
+		filter.From = filter.To.Add(-defaultPeriod)
+		if req.From != nil {
+			filter.From = *req.From
+		}
+		if !filter.To.After(filter.From) || filter.To.Sub(filter.From) > maxPeriod {
+			log.Error("invalid period", slog.Time("from", filter.From), slog.Time("to", filter.To))
+
+			render.JSON(w, r, resp.Error("field to must be after from and the period at most 366 days"))
+
+			return
+		}
+
+		if p := access.FromContext(r.Context()); p != nil && !p.All() {
+			filter.Namespaces = p.Namespaces
+			if filter.Namespaces == nil {
+				filter.Namespaces = []string{}
+			}
+		}
+
+		stats, err := segmentStatsGetter.GetSegmentStats(access.Tenant(r.Context()), filter)
+		if errors.Is(err, storage.ErrSegmentNotFound) {
+			log.Info("segment not found", sl.Err(err))
+
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+		if errors.Is(err, storage.ErrSegmentRuleBased) {
+			log.Info("stats of a rule segment", sl.Err(err))
+
+			render.Status(r, http.StatusUnprocessableEntity)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to get segment stats", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get segment stats"))
+
+			return
+		}
+
+		log.Info("get segment stats", slog.Int("segments", len(stats.Segments)))
+
+		responseOK(w, r, stats)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, stats *storage.SegmentStatsDTO) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Stats:    stats,
+	})
+}
@@ -0,0 +1,64 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeStatsGetter treats the segments listed in rules as rule segments, the
+// way the storage does.
+type fakeStatsGetter struct {
+	rules map[string]bool
+}
+
+func (g *fakeStatsGetter) GetSegmentStats(tenant string, filter storage.SegmentStatsFilterDTO) (*storage.SegmentStatsDTO, error) {
+	for _, name := range filter.Segments {
+		if g.rules[name] {
+			return nil, fmt.Errorf("%w: %s", storage.ErrSegmentRuleBased, name)
+		}
+	}
+	return &storage.SegmentStatsDTO{Segments: make([]storage.SegmentStatDTO, 0)}, nil
+}
+
+func TestStats(t *testing.T) {
+	getter := &fakeStatsGetter{rules: map[string]bool{"MOSCOW_IOS": true}}
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), getter)
+
+	tests := []struct {
+		name       string
+		segments   []string
+		wantStatus int
+		wantError  string
+	}{
+		{name: "all segments", wantStatus: http.StatusOK},
+		{name: "explicit segments", segments: []string{"VOICE_MESSAGES"}, wantStatus: http.StatusOK},
+		{name: "rule segment", segments: []string{"VOICE_MESSAGES", "MOSCOW_IOS"}, wantStatus: http.StatusUnprocessableEntity, wantError: "MOSCOW_IOS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(Request{Segments: tt.segments})
+			r := httptest.NewRequest(http.MethodGet, "/segment/stats", strings.NewReader(string(body)))
+			w := httptest.NewRecorder()
+
+			handler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			var res Response
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if !strings.Contains(res.Error, tt.wantError) {
+				t.Errorf("error = %q, want it to mention %q", res.Error, tt.wantError)
+			}
+		})
+	}
+}
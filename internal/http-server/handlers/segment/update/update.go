@@ -0,0 +1,117 @@
+package update
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+// Request changes only the fields that are present.
+type Request struct {
+	Name        string    `json:"Name" validate:"required"`
+	Description *string   `json:"description,omitempty"`
+	Owner       *string   `json:"owner,omitempty"`
+	Contact     *string   `json:"contact,omitempty"`
+	Tags        *[]string `json:"tags,omitempty" validate:"omitempty,dive,required"`
+}
+
+type Response struct {
+	resp.Response
+	Segment *storage.SegmentInfoDTO `json:"segment,omitempty"`
+}
+
+type SegmentUpdater interface {
+	UpdateSegment(tenant string, name string, patch storage.SegmentPatchDTO) (*storage.SegmentInfoDTO, error)
+}
+
+func New(log *slog.Logger, segmentUpdater SegmentUpdater, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.segment.update.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		if err := access.Check(r.Context(), req.Name); err != nil {
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		segment, err := segmentUpdater.UpdateSegment(access.Tenant(r.Context()), req.Name, storage.SegmentPatchDTO{
+			Description: req.Description,
+			Owner:       req.Owner,
+			Contact:     req.Contact,
+			Tags:        req.Tags,
+		})
+		audit.Record(log, auditor, r, audit.ActionSegmentUpdate, req, err)
+		if errors.Is(err, storage.ErrSegmentNotFound) {
+			log.Info("segment not found", slog.String("name", req.Name))
+
+			render.JSON(w, r, resp.Error("segment not found"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to update segment", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to update segment"))
+
+			return
+		}
+
+		log.Info("segment updated", slog.String("name", req.Name))
+
+		responseOK(w, r, segment)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, segment *storage.SegmentInfoDTO) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Segment:  segment,
+	})
+}
@@ -0,0 +1,102 @@
+package attributes
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Request struct {
+	Id         int64          `json:"id" validate:"required"`
+	Attributes map[string]any `json:"attributes" validate:"required"`
+	Replace    bool           `json:"replace,omitempty"`
+}
+
+type Response struct {
+	resp.Response
+	Id         int64          `json:"id,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+type UserAttributesSaver interface {
+	SaveUserAttributes(tenant string, userId int64, attrs map[string]any, replace bool) (*storage.UserAttributesDTO, error)
+}
+
+func New(log *slog.Logger, userAttributesSaver UserAttributesSaver, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.attributes.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		res, err := userAttributesSaver.SaveUserAttributes(access.Tenant(r.Context()), req.Id, req.Attributes, req.Replace)
+		audit.Record(log, auditor, r, audit.ActionUserAttributes, req, err)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", slog.Int64("id", req.Id))
+
+			render.JSON(w, r, resp.Error("user not found"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to save user attributes", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to save user attributes"))
+
+			return
+		}
+
+		log.Info("user attributes saved", slog.Int64("id", req.Id))
+
+		responseOK(w, r, res)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, attrs *storage.UserAttributesDTO) {
+	render.JSON(w, r, Response{
+		Response:   resp.OK(),
+		Id:         attrs.UserID,
+		Attributes: attrs.Attributes,
+	})
+}
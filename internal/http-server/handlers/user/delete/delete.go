@@ -2,7 +2,9 @@ package delete
 
 import (
 	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
 	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
@@ -22,14 +24,14 @@ type Response struct {
 }
 
 type UserDeleter interface {
-	DeleteUser(userId int64) error
+	DeleteUser(tenant string, userId int64) error
 }
 
-func New(log *slog.Logger, userDeleter UserDeleter) http.HandlerFunc {
+func New(log *slog.Logger, userDeleter UserDeleter, auditor audit.Recorder) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.user.delete.New"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
@@ -64,9 +66,20 @@ func New(log *slog.Logger, userDeleter UserDeleter) http.HandlerFunc {
 			return
 		}
 
+		// Memberships of every namespace go with the user.
+		if p := access.FromContext(r.Context()); p != nil && !p.All() {
+			log.Info("access denied", slog.String("principal", p.Name))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("deleting a user requires access to all namespaces"))
+
+			return
+		}
+
 		id := req.Id
 
-		err = userDeleter.DeleteUser(id)
+		err = userDeleter.DeleteUser(access.Tenant(r.Context()), id)
+		audit.Record(log, auditor, r, audit.ActionUserDelete, req, err)
 		if err != nil {
 			log.Error("failed to delete user", sl.Err(err))
 
@@ -0,0 +1,187 @@
+package replace
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/etag"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+// Request sets the segments of user Id to exactly Segments. An empty list,
+// unlike a missing one, removes the user from all of them. A principal
+// limited to some namespaces only replaces the segments in those namespaces.
+type Request struct {
+	Id       int64    `json:"id" validate:"required"`
+	Segments []string `json:"segments" validate:"required,max=1000,dive,required"`
+}
+
+type Response struct {
+	resp.Response
+	Id      int64    `json:"id,omitempty"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Version int64    `json:"version"`
+}
+
+type UserSegmentsSetter interface {
+	access.Resolver
+	SetUserSegments(tenant string, userId int64, segments []string, namespaces []string, ifVersion *int64) (*storage.UserSegmentsDiffDTO, error)
+}
+
+func New(log *slog.Logger, userSegmentsSetter UserSegmentsSetter, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.replace.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		// Aliases are checked against the segment they point to.
+		if err := access.CheckResolved(r.Context(), userSegmentsSetter, req.Segments...); err != nil {
+			var denied *access.DeniedError
+			if !errors.As(err, &denied) {
+				log.Error("failed to resolve segments", sl.Err(err))
+
+				render.JSON(w, r, resp.Error("failed to resolve segments"))
+
+				return
+			}
+
+			log.Info("access denied", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		var namespaces []string
+		if p := access.FromContext(r.Context()); p != nil && !p.All() {
+			namespaces = p.Namespaces
+			if namespaces == nil {
+				namespaces = []string{}
+			}
+		}
+
+		ifVersion, err := etag.IfMatch(r)
+		if err != nil {
+			log.Info("invalid If-Match", sl.Err(err))
+
+			render.Status(r, http.StatusPreconditionFailed)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		res, err := userSegmentsSetter.SetUserSegments(access.Tenant(r.Context()), req.Id, req.Segments, namespaces, ifVersion)
+		audit.Record(log, auditor, r, audit.ActionUserSegmentsSet, req, err)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", slog.Int64("id", req.Id))
+
+			render.JSON(w, r, resp.Error("user not found"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrSegmentNotFound) || errors.Is(err, storage.ErrSegmentArchived) || errors.Is(err, storage.ErrSegmentComputed) {
+			log.Info("segment can not be set", sl.Err(err))
+
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			log.Info("user segments changed since If-Match version", sl.Err(err))
+
+			render.Status(r, http.StatusPreconditionFailed)
+			render.JSON(w, r, resp.Error("user segments changed, fetch them again"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			log.Info("tenant quota exceeded", sl.Err(err))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("quota exceeded"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrSegmentConflict) {
+			log.Info("exclusive segment conflict", sl.Err(err))
+
+			render.Status(r, http.StatusConflict)
+			render.JSON(w, r, resp.Error(conflictMessage(err)))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to replace user segments", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to replace user segments"))
+
+			return
+		}
+
+		log.Info("user segments replaced", slog.Int64("id", res.UserID), slog.Any("added", res.Added), slog.Any("removed", res.Removed))
+
+		responseOK(w, r, res)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, diff *storage.UserSegmentsDiffDTO) {
+	w.Header().Set(etag.HeaderETag, etag.Format(diff.Version))
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Id:       diff.UserID,
+		Added:    diff.Added,
+		Removed:  diff.Removed,
+		Version:  diff.Version,
+	})
+}
+
+func conflictMessage(err error) string {
+	var conflictErr *storage.SegmentConflictError
+	if errors.As(err, &conflictErr) {
+		return conflictErr.Error()
+	}
+	return "segment conflicts with another segment of an exclusive group"
+}
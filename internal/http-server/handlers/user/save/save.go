@@ -1,47 +1,154 @@
 package save
 
 import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
 	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
 	"golang.org/x/exp/slog"
+	"io"
 	"net/http"
 )
 
+const onConflictIgnore = "ignore"
+
+// Request is optional: without ids the user gets a server generated id.
+type Request struct {
+	Id         int64   `json:"id,omitempty" validate:"gte=0"`
+	Ids        []int64 `json:"ids,omitempty" validate:"max=1000,dive,gt=0"`
+	OnConflict string  `json:"on_conflict,omitempty" validate:"omitempty,oneof=error ignore"`
+}
+
 type Response struct {
 	resp.Response
-	Id int64 `json:"id,omitempty"`
+	Id       int64   `json:"id,omitempty"`
+	Created  []int64 `json:"created,omitempty"`
+	Existing []int64 `json:"existing,omitempty"`
 }
 
 type UserSaver interface {
-	SaveUser() (*storage.UserDTO, error)
+	SaveUser(tenant string) (*storage.UserDTO, error)
+	SaveUsers(tenant string, ids []int64, ignoreExisting bool) (*storage.SavedUsersDTO, error)
 }
 
-func New(log *slog.Logger, userSaver UserSaver) http.HandlerFunc {
+func New(log *slog.Logger, userSaver UserSaver, auditor audit.Recorder) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.user.save.New"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
 
-		res, err := userSaver.SaveUser()
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		ids := req.Ids
+		if req.Id != 0 {
+			ids = append([]int64{req.Id}, ids...)
+		}
+
+		if len(ids) > 0 {
+			saveExternal(log, w, r, userSaver, auditor, req, ids)
+			return
+		}
+
+		res, err := userSaver.SaveUser(access.Tenant(r.Context()))
 		if err != nil {
+			audit.Record(log, auditor, r, audit.ActionUserSave, nil, err)
+			if errors.Is(err, storage.ErrQuotaExceeded) {
+				log.Info("tenant quota exceeded", sl.Err(err))
+
+				render.Status(r, http.StatusForbidden)
+				render.JSON(w, r, resp.Error("quota exceeded"))
+
+				return
+			}
+
 			log.Error("failed to save user", sl.Err(err))
 
 			render.JSON(w, r, resp.Error("failed to save user"))
 
 			return
 		}
+		audit.Record(log, auditor, r, audit.ActionUserSave, res, nil)
+
 		log.Info("user added", slog.Int64("id", res.ID))
 
 		responseOK(w, r, res.ID)
 	}
 }
 
+func saveExternal(log *slog.Logger, w http.ResponseWriter, r *http.Request, userSaver UserSaver, auditor audit.Recorder, req Request, ids []int64) {
+	log.Info("request body decoded", slog.Any("request", req))
+
+	res, err := userSaver.SaveUsers(access.Tenant(r.Context()), ids, req.OnConflict == onConflictIgnore)
+	audit.Record(log, auditor, r, audit.ActionUserSave, req, err)
+	if errors.Is(err, storage.ErrQuotaExceeded) {
+		log.Info("tenant quota exceeded", sl.Err(err))
+
+		render.Status(r, http.StatusForbidden)
+		render.JSON(w, r, resp.Error("quota exceeded"))
+
+		return
+	}
+	if errors.Is(err, storage.ErrUserExists) {
+		log.Info("users already exist", slog.Any("ids", res.Existing))
+
+		render.Status(r, http.StatusConflict)
+		render.JSON(w, r, Response{
+			Response: resp.Error("user already exists"),
+			Existing: res.Existing,
+		})
+
+		return
+	}
+	if err != nil {
+		log.Error("failed to save users", sl.Err(err))
+
+		render.JSON(w, r, resp.Error("failed to save user"))
+
+		return
+	}
+
+	log.Info("users added", slog.Any("created", res.Created), slog.Any("existing", res.Existing))
+
+	var id int64
+	if len(ids) == 1 {
+		id = ids[0]
+	}
+
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Id:       id,
+		Created:  res.Created,
+		Existing: res.Existing,
+	})
+}
+
 func responseOK(w http.ResponseWriter, r *http.Request, userId int64) {
 	render.JSON(w, r, Response{
 		Response: resp.OK(),
@@ -2,6 +2,8 @@ package segments
 
 import (
 	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/etag"
 	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
@@ -23,7 +25,7 @@ type Response struct {
 }
 
 type UserSegmentsGetter interface {
-	GetUserSegments(userId int64) (*storage.UserSegmentsDTO, error)
+	GetUserSegments(tenant string, userId int64) (*storage.UserSegmentsDTO, error)
 }
 
 func New(log *slog.Logger, userSegmentsGetter UserSegmentsGetter) http.HandlerFunc {
@@ -67,7 +69,7 @@ func New(log *slog.Logger, userSegmentsGetter UserSegmentsGetter) http.HandlerFu
 
 		id := req.Id
 
-		userSegments, err := userSegmentsGetter.GetUserSegments(id)
+		userSegments, err := userSegmentsGetter.GetUserSegments(access.Tenant(r.Context()), id)
 		if err != nil {
 			log.Error("failed to get user segments", sl.Err(err))
 
@@ -83,6 +85,7 @@ func New(log *slog.Logger, userSegmentsGetter UserSegmentsGetter) http.HandlerFu
 }
 
 func responseOK(w http.ResponseWriter, r *http.Request, userSegments *storage.UserSegmentsDTO) {
+	w.Header().Set(etag.HeaderETag, etag.Format(userSegments.Version))
 	render.JSON(w, r, Response{
 		Response: resp.OK(),
 		Segments: *userSegments,
@@ -0,0 +1,223 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/config"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	eventSegments = "segments"
+
+	retryMillis = 3000
+)
+
+type Snapshot struct {
+	UserID   int64    `json:"user_id"`
+	Segments []string `json:"segments"`
+}
+
+type Event struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	UserID    int64     `json:"user_id"`
+	Segment   string    `json:"segment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Subscriber interface {
+	Subscribe(tenant string, userId int64) (<-chan storage.EventDTO, func())
+}
+
+type UserEventsGetter interface {
+	GetUserSegments(tenant string, userId int64) (*storage.UserSegmentsDTO, error)
+	GetUserEvents(tenant string, userId, afterId int64, limit int) ([]storage.EventDTO, error)
+	GetLastUserEventId(tenant string, userId int64) (int64, error)
+}
+
+// New streams the user's explicit membership changes. Segments whose effect
+// changes without one, by a rule edit, archiving, a restore or their window
+// opening or closing, emit no event: clients see them only in the next
+// segments snapshot or in GET /user/segments.
+func New(log *slog.Logger, cfg config.Stream, subscriber Subscriber, userEventsGetter UserEventsGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.stream.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		// EventSource can not send a body, so the user comes in the query string.
+		userId, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil || userId <= 0 {
+			log.Error("invalid user id", slog.String("id", r.URL.Query().Get("id")))
+
+			render.JSON(w, r, resp.Error("field id is not valid"))
+
+			return
+		}
+
+		var lastEventId int64
+		if header := r.Header.Get("Last-Event-ID"); header != "" {
+			lastEventId, err = strconv.ParseInt(header, 10, 64)
+			if err != nil || lastEventId < 0 {
+				log.Error("invalid Last-Event-ID", slog.String("last_event_id", header))
+
+				render.JSON(w, r, resp.Error("invalid Last-Event-ID"))
+
+				return
+			}
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			log.Error("streaming is not supported")
+
+			render.JSON(w, r, resp.Error("streaming is not supported"))
+
+			return
+		}
+
+		tenant := access.Tenant(r.Context())
+		log = log.With(slog.Int64("user_id", userId))
+
+		// Subscribe before reading the current state, so nothing committed in
+		// between is lost. Events already reflected in a snapshot may come
+		// twice, applying them again changes nothing.
+		events, cancel := subscriber.Subscribe(tenant, userId)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "retry: %d\n\n", retryMillis)
+
+		// Ids of the events sent by the replay, the live ones are not sent
+		// again.
+		var sent map[int64]struct{}
+		if lastEventId > 0 {
+			lastEventId, sent, err = replay(w, userEventsGetter, tenant, userId, lastEventId, cfg.ReplayLimit)
+		} else {
+			lastEventId, err = snapshot(w, userEventsGetter, tenant, userId)
+		}
+		if err != nil {
+			log.Error("failed to send initial state", sl.Err(err))
+			return
+		}
+		flusher.Flush()
+
+		log.Info("stream opened", slog.Int64("last_event_id", lastEventId))
+
+		heartbeat := time.NewTicker(cfg.Heartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				log.Info("stream closed by client")
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case event, ok := <-events:
+				if !ok {
+					// The subscriber fell behind, the client reconnects with Last-Event-ID.
+					log.Warn("stream subscriber dropped")
+					return
+				}
+				// Ids are taken before commit, so an event committed after
+				// the initial state may have a lower id than the state covers.
+				// Only events the replay has actually sent are skipped.
+				if _, ok := sent[event.ID]; ok {
+					continue
+				}
+				if err := writeEvent(w, event); err != nil {
+					log.Error("failed to write event", sl.Err(err))
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// replay sends the events missed since lastEventId and returns the ids it
+// sent. When there are more than limit of them a fresh snapshot is cheaper
+// for the client.
+func replay(w http.ResponseWriter, getter UserEventsGetter, tenant string, userId, lastEventId int64, limit int) (int64, map[int64]struct{}, error) {
+	events, err := getter.GetUserEvents(tenant, userId, lastEventId, limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(events) >= limit {
+		lastEventId, err := snapshot(w, getter, tenant, userId)
+		return lastEventId, nil, err
+	}
+
+	sent := make(map[int64]struct{}, len(events))
+	for _, event := range events {
+		if err := writeEvent(w, event); err != nil {
+			return 0, nil, err
+		}
+		sent[event.ID] = struct{}{}
+		lastEventId = event.ID
+	}
+
+	return lastEventId, sent, nil
+}
+
+func snapshot(w http.ResponseWriter, getter UserEventsGetter, tenant string, userId int64) (int64, error) {
+	lastEventId, err := getter.GetLastUserEventId(tenant, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	userSegments, err := getter.GetUserSegments(tenant, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	segments := make([]string, 0, len(userSegments.Segments))
+	for _, segment := range userSegments.Segments {
+		segments = append(segments, segment.Name)
+	}
+
+	data, err := json.Marshal(Snapshot{UserID: userId, Segments: segments})
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", lastEventId, eventSegments, data)
+	return lastEventId, err
+}
+
+func writeEvent(w http.ResponseWriter, event storage.EventDTO) error {
+	data, err := json.Marshal(Event{
+		ID:        event.ID,
+		Type:      event.Type,
+		UserID:    event.UserID,
+		Segment:   event.Segment,
+		CreatedAt: event.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -0,0 +1,98 @@
+package deadLetters
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+const defaultLimit = 100
+
+type Request struct {
+	Limit  int `json:"limit,omitempty" validate:"gte=0,lte=1000"`
+	Offset int `json:"offset,omitempty" validate:"gte=0"`
+}
+
+type Response struct {
+	resp.Response
+	DeadLetters []storage.DeadLetterDTO `json:"dead_letters,omitempty"`
+}
+
+type DeadLettersGetter interface {
+	GetWebhookDeadLetters(tenant string, limit, offset int) ([]storage.DeadLetterDTO, error)
+}
+
+func New(log *slog.Logger, deadLettersGetter DeadLettersGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.webhook.deadLetters.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		// Dead letters carry events of every namespace.
+		if p := access.FromContext(r.Context()); p != nil && !p.All() {
+			log.Info("access denied", slog.String("principal", p.Name))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("reading dead letters requires access to all namespaces"))
+
+			return
+		}
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		limit := req.Limit
+		if limit == 0 {
+			limit = defaultLimit
+		}
+
+		letters, err := deadLettersGetter.GetWebhookDeadLetters(access.Tenant(r.Context()), limit, req.Offset)
+		if err != nil {
+			log.Error("failed to get dead letters", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get dead letters"))
+
+			return
+		}
+
+		log.Info("get dead letters", slog.Int("count", len(letters)))
+
+		responseOK(w, r, letters)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, letters []storage.DeadLetterDTO) {
+	render.JSON(w, r, Response{
+		Response:    resp.OK(),
+		DeadLetters: letters,
+	})
+}
@@ -0,0 +1,108 @@
+package delete
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Request struct {
+	Id int64 `json:"id" validate:"required"`
+}
+
+type Response struct {
+	resp.Response
+	Id int64 `json:"id,omitempty"`
+}
+
+type WebhookDeleter interface {
+	DeleteWebhook(tenant string, id int64) error
+}
+
+func New(log *slog.Logger, webhookDeleter WebhookDeleter, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.webhook.delete.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		// Webhooks receive events of every namespace.
+		if p := access.FromContext(r.Context()); p != nil && !p.All() {
+			log.Info("access denied", slog.String("principal", p.Name))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("managing webhooks requires access to all namespaces"))
+
+			return
+		}
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		err = webhookDeleter.DeleteWebhook(access.Tenant(r.Context()), req.Id)
+		audit.Record(log, auditor, r, audit.ActionWebhookDelete, req, err)
+		if errors.Is(err, storage.ErrWebhookNotFound) {
+			log.Info("webhook not found", slog.Int64("id", req.Id))
+
+			render.JSON(w, r, resp.Error("webhook not found"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to delete webhook", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to delete webhook"))
+
+			return
+		}
+
+		log.Info("webhook deleted", slog.Int64("id", req.Id))
+
+		responseOK(w, r, req.Id)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, id int64) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Id:       id,
+	})
+}
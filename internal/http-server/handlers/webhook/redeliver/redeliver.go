@@ -0,0 +1,108 @@
+package redeliver
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Request struct {
+	Id int64 `json:"id" validate:"required"`
+}
+
+type Response struct {
+	resp.Response
+	Id int64 `json:"id,omitempty"`
+}
+
+type DeliveryRequeuer interface {
+	RequeueWebhookDelivery(tenant string, deliveryId int64) error
+}
+
+func New(log *slog.Logger, deliveryRequeuer DeliveryRequeuer, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.webhook.redeliver.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		// Dead letters carry events of every namespace.
+		if p := access.FromContext(r.Context()); p != nil && !p.All() {
+			log.Info("access denied", slog.String("principal", p.Name))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("redelivering webhooks requires access to all namespaces"))
+
+			return
+		}
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		err = deliveryRequeuer.RequeueWebhookDelivery(access.Tenant(r.Context()), req.Id)
+		audit.Record(log, auditor, r, audit.ActionWebhookRedeliver, req, err)
+		if errors.Is(err, storage.ErrDeliveryNotFound) {
+			log.Info("dead letter not found", slog.Int64("id", req.Id))
+
+			render.JSON(w, r, resp.Error("dead letter not found"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to requeue webhook delivery", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to requeue webhook delivery"))
+
+			return
+		}
+
+		log.Info("webhook delivery requeued", slog.Int64("id", req.Id))
+
+		responseOK(w, r, req.Id)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, id int64) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Id:       id,
+	})
+}
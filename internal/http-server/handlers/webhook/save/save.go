@@ -0,0 +1,112 @@
+package save
+
+import (
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audit"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+)
+
+type Request struct {
+	URL    string `json:"url" validate:"required,url"`
+	Secret string `json:"secret" validate:"required,min=16"`
+}
+
+type Response struct {
+	resp.Response
+	Id  int64  `json:"id,omitempty"`
+	URL string `json:"url,omitempty"`
+}
+
+type WebhookSaver interface {
+	SaveWebhook(tenant string, url, secret string) (*storage.WebhookDTO, error)
+}
+
+func New(log *slog.Logger, webhookSaver WebhookSaver, auditor audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.webhook.save.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		// Webhooks receive events of every namespace.
+		if p := access.FromContext(r.Context()); p != nil && !p.All() {
+			log.Info("access denied", slog.String("principal", p.Name))
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, resp.Error("managing webhooks requires access to all namespaces"))
+
+			return
+		}
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.String("url", req.URL))
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		webhook, err := webhookSaver.SaveWebhook(access.Tenant(r.Context()), req.URL, req.Secret)
+		// The secret is never written to the audit log.
+		audit.Record(log, auditor, r, audit.ActionWebhookSave, map[string]string{"url": req.URL}, err)
+		if errors.Is(err, storage.ErrWebhookExists) {
+			log.Info("webhook already exists", slog.String("url", req.URL))
+
+			render.JSON(w, r, resp.Error("webhook already exists"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to save webhook", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to save webhook"))
+
+			return
+		}
+
+		log.Info("webhook saved", slog.Int64("id", webhook.ID))
+
+		responseOK(w, r, webhook)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, webhook *storage.WebhookDTO) {
+	render.JSON(w, r, Response{
+		Response: resp.OK(),
+		Id:       webhook.ID,
+		URL:      webhook.URL,
+	})
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/config"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/client"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"golang.org/x/exp/slog"
+	"net/http"
+)
+
+// New authenticates callers by their API key and stores the matching
+// principal in the request context for namespace checks.
+func New(log *slog.Logger, cfg config.Auth) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !cfg.Enabled {
+			return next
+		}
+
+		log := log.With(
+			slog.String("component", "middleware/auth"),
+		)
+
+		principals := make(map[string]*access.Principal, len(cfg.Principals))
+		for _, p := range cfg.Principals {
+			principals[p.APIKey] = &access.Principal{Name: p.Name, Namespaces: p.Namespaces, Tenant: p.Tenant}
+		}
+
+		log.Info("auth middleware enabled", slog.Int("principals", len(principals)))
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(client.HeaderAPIKey)
+			p, ok := principals[key]
+			if key == "" || !ok {
+				log.Warn("unauthorized request",
+					slog.String("ip", client.IP(r)),
+					slog.String("path", r.URL.Path),
+					slog.String("request_id", middleware.GetReqID(r.Context())),
+				)
+
+				render.Status(r, http.StatusUnauthorized)
+				render.JSON(w, r, resp.Error("unauthorized"))
+
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(access.WithPrincipal(r.Context(), p)))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
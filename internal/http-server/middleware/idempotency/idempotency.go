@@ -0,0 +1,205 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/config"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/client"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+
+	maxKeyLength    = 256
+	cleanupInterval = time.Hour
+)
+
+type Store interface {
+	ReserveIdempotencyKey(client, key, requestHash string, ttl time.Duration) (*storage.IdempotencyRecordDTO, error)
+	CompleteIdempotencyKey(client, key string, status int, response []byte) error
+	ReleaseIdempotencyKey(client, key string) error
+	DeleteExpiredIdempotencyKeys() (int64, error)
+}
+
+func New(log *slog.Logger, cfg config.Idempotency, store Store) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		log := log.With(
+			slog.String("component", "middleware/idempotency"),
+		)
+
+		log.Info("idempotency middleware enabled", slog.String("ttl", cfg.TTL.String()))
+
+		var (
+			mu          sync.Mutex
+			lastCleanup time.Time
+		)
+		cleanup := func() {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if time.Since(lastCleanup) < cleanupInterval {
+				return
+			}
+			lastCleanup = time.Now()
+
+			go func() {
+				deleted, err := store.DeleteExpiredIdempotencyKeys()
+				if err != nil {
+					log.Error("failed to delete expired idempotency keys", sl.Err(err))
+					return
+				}
+				log.Debug("expired idempotency keys deleted", slog.Int64("count", deleted))
+			}()
+		}
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(HeaderKey)
+			if key == "" || !mutating(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			log := log.With(
+				slog.String("request_id", middleware.GetReqID(r.Context())),
+				slog.String("idempotency_key", key),
+			)
+
+			if len(key) > maxKeyLength {
+				render.Status(r, http.StatusBadRequest)
+				render.JSON(w, r, resp.Error("idempotency key is too long"))
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				log.Error("failed to read request body", sl.Err(err))
+
+				render.Status(r, http.StatusBadRequest)
+				render.JSON(w, r, resp.Error("failed to read request"))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			cleanup()
+
+			// Keys are only unique per client within a tenant.
+			clientKey := access.Tenant(r.Context()) + "/" + client.Key(r)
+			hash := requestHash(r, body)
+
+			record, err := store.ReserveIdempotencyKey(clientKey, key, hash, cfg.TTL)
+			switch {
+			case errors.Is(err, storage.ErrIdempotencyKeyExists):
+				replay(log, w, r, record, hash)
+				return
+			case err != nil:
+				log.Error("failed to reserve idempotency key", sl.Err(err))
+
+				render.Status(r, http.StatusInternalServerError)
+				render.JSON(w, r, resp.Error("failed to process idempotency key"))
+				return
+			}
+
+			var buf bytes.Buffer
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(&buf)
+
+			completed := false
+			defer func() {
+				if completed {
+					return
+				}
+				if err := store.ReleaseIdempotencyKey(clientKey, key); err != nil {
+					log.Error("failed to release idempotency key", sl.Err(err))
+				}
+			}()
+
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			// Server errors are not remembered so that the client can retry them.
+			// Handlers report most failures, storage ones included, as an
+			// error body with status 200; only explicit 4xx answers are final.
+			if status >= http.StatusInternalServerError || (status < http.StatusBadRequest && isError(buf.Bytes())) {
+				return
+			}
+
+			if err := store.CompleteIdempotencyKey(clientKey, key, status, buf.Bytes()); err != nil {
+				log.Error("failed to store idempotent response", sl.Err(err))
+				return
+			}
+			completed = true
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+func replay(log *slog.Logger, w http.ResponseWriter, r *http.Request, record *storage.IdempotencyRecordDTO, hash string) {
+	if record.RequestHash != hash {
+		log.Warn("idempotency key reused with a different payload")
+
+		render.Status(r, http.StatusUnprocessableEntity)
+		render.JSON(w, r, resp.Error("idempotency key was already used with a different request"))
+		return
+	}
+
+	if !record.Completed {
+		log.Warn("request with the same idempotency key is in progress")
+
+		render.Status(r, http.StatusConflict)
+		render.JSON(w, r, resp.Error("request with this idempotency key is in progress"))
+		return
+	}
+
+	log.Info("replaying stored response")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(record.Status)
+	_, _ = w.Write(record.Response)
+}
+
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isError reports whether body is an error response of the API.
+func isError(body []byte) bool {
+	var response resp.Response
+	if err := json.Unmarshal(body, &response); err != nil {
+		return false
+	}
+	return response.Status == resp.StatusError
+}
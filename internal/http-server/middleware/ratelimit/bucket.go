@@ -0,0 +1,34 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket for the time passed since the last call and tries
+// to consume one token. When the bucket is empty it reports how long the
+// caller has to wait for the next token.
+func (b *bucket) take(now time.Time, limit rate) (bool, time.Duration) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(limit.burst), b.tokens+elapsed*limit.rps)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := (1 - b.tokens) / limit.rps
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+func (b *bucket) full(now time.Time, limit rate) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*limit.rps >= float64(limit.burst)
+}
@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"expvar"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/config"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/client"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"golang.org/x/exp/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+var rejected = expvar.NewMap("ratelimit_rejected")
+
+type rate struct {
+	rps   float64
+	burst int
+}
+
+type bucketKey struct {
+	client string
+	route  string
+}
+
+type limiter struct {
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	def       rate
+	routes    map[string]rate
+	lastSweep time.Time
+}
+
+func newLimiter(cfg config.RateLimit) *limiter {
+	l := &limiter{
+		buckets: make(map[bucketKey]*bucket),
+		def:     rate{rps: cfg.Default.RPS, burst: cfg.Default.Burst},
+		routes:  make(map[string]rate, len(cfg.Routes)),
+	}
+	for route, limit := range cfg.Routes {
+		l.routes[route] = rate{rps: limit.RPS, burst: limit.Burst}
+	}
+	return l
+}
+
+func (l *limiter) limitFor(route string) rate {
+	if limit, ok := l.routes[route]; ok {
+		return limit
+	}
+	return l.def
+}
+
+func (l *limiter) allow(key, route string, now time.Time) (bool, time.Duration) {
+	limit := l.limitFor(route)
+	if limit.rps <= 0 || limit.burst <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	id := bucketKey{client: key, route: route}
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{tokens: float64(limit.burst), last: now}
+		l.buckets[id] = b
+	}
+
+	return b.take(now, limit)
+}
+
+// sweep drops buckets that have refilled completely, they are
+// indistinguishable from fresh ones.
+func (l *limiter) sweep(now time.Time) {
+	for id, b := range l.buckets {
+		if b.full(now, l.limitFor(id.route)) {
+			delete(l.buckets, id)
+		}
+	}
+	l.lastSweep = now
+}
+
+func New(log *slog.Logger, cfg config.RateLimit) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !cfg.Enabled {
+			return next
+		}
+
+		log := log.With(
+			slog.String("component", "middleware/ratelimit"),
+		)
+
+		log.Info("rate limit middleware enabled")
+
+		l := newLimiter(cfg)
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := client.Key(r)
+			route := routePath(r)
+
+			ok, wait := l.allow(key, route, time.Now())
+			if ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rejected.Add(route, 1)
+
+			log.Warn("rate limit exceeded",
+				slog.String("client", key),
+				slog.String("path", route),
+				slog.String("request_id", middleware.GetReqID(r.Context())),
+			)
+
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			render.Status(r, http.StatusTooManyRequests)
+			render.JSON(w, r, resp.Error("too many requests"))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// routePath is the path the router matches, without the extension that
+// middleware.URLFormat strips, so /segment/addToUser.json shares the limit
+// of /segment/addToUser.
+func routePath(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePath != "" {
+		return rctx.RoutePath
+	}
+	return r.URL.Path
+}
@@ -0,0 +1,65 @@
+package tenant
+
+import (
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/config"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"golang.org/x/exp/slog"
+	"net/http"
+)
+
+const HeaderTenant = "X-Tenant"
+
+// New selects the tenant of the request: the one the API key is pinned to,
+// otherwise the X-Tenant header, otherwise the default tenant.
+func New(log *slog.Logger, cfg config.Tenants) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		log := log.With(
+			slog.String("component", "middleware/tenant"),
+		)
+
+		def := cfg.Default
+		if def == "" {
+			def = access.DefaultTenant
+		}
+
+		log.Info("tenant middleware enabled", slog.String("default", def), slog.Int("tenants", len(cfg.Quotas)))
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			log := log.With(
+				slog.String("request_id", middleware.GetReqID(r.Context())),
+			)
+
+			tenant := r.Header.Get(HeaderTenant)
+			if p := access.FromContext(r.Context()); p != nil && p.Tenant != "" {
+				if tenant != "" && tenant != p.Tenant {
+					log.Warn("tenant is not granted", slog.String("principal", p.Name), slog.String("tenant", tenant))
+
+					render.Status(r, http.StatusForbidden)
+					render.JSON(w, r, resp.Error("no access to tenant "+tenant))
+
+					return
+				}
+				tenant = p.Tenant
+			}
+			if tenant == "" {
+				tenant = def
+			}
+
+			if _, ok := cfg.Quotas[tenant]; !ok && tenant != def {
+				log.Warn("unknown tenant", slog.String("tenant", tenant))
+
+				render.Status(r, http.StatusBadRequest)
+				render.JSON(w, r, resp.Error("unknown tenant "+tenant))
+
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(access.WithTenant(r.Context(), tenant)))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
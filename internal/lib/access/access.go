@@ -0,0 +1,139 @@
+package access
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DefaultNamespace holds segments whose slug has no namespace prefix.
+const DefaultNamespace = "default"
+
+// AllNamespaces grants access to every namespace.
+const AllNamespaces = "*"
+
+// Namespace returns the namespace of a segment slug: the part before the
+// first slash, e.g. "messenger" for "messenger/VOICE_MESSAGES".
+func Namespace(segment string) string {
+	namespace, _, found := strings.Cut(segment, "/")
+	if !found || namespace == "" {
+		return DefaultNamespace
+	}
+	return namespace
+}
+
+type Principal struct {
+	Name       string
+	Namespaces []string
+	Tenant     string
+}
+
+func (p *Principal) All() bool {
+	for _, namespace := range p.Namespaces {
+		if namespace == AllNamespaces {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Principal) Allows(namespace string) bool {
+	for _, granted := range p.Namespaces {
+		if granted == namespace || granted == AllNamespaces {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultTenant is used when no tenant was selected for the request.
+const DefaultTenant = "default"
+
+type ctxKey struct{}
+
+type tenantCtxKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext returns the authenticated principal, nil when authentication
+// is disabled.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(ctxKey{}).(*Principal)
+	return p
+}
+
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantCtxKey{}, tenant)
+}
+
+// Tenant returns the tenant every storage call of the request is scoped to.
+func Tenant(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantCtxKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}
+
+type DeniedError struct {
+	Principal string
+	Segments  []string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("%s has no access to namespaces of segments: %s", e.Principal, strings.Join(e.Segments, ", "))
+}
+
+// Check returns a *DeniedError listing the segments whose namespaces are not
+// granted to the principal of ctx.
+func Check(ctx context.Context, segments ...string) error {
+	p := FromContext(ctx)
+	if p == nil {
+		return nil
+	}
+
+	var denied []string
+	for _, segment := range segments {
+		if !p.Allows(Namespace(segment)) {
+			denied = append(denied, segment)
+		}
+	}
+	if len(denied) > 0 {
+		return &DeniedError{Principal: p.Name, Segments: denied}
+	}
+
+	return nil
+}
+
+// Resolver maps segment names, current ones or aliases, to current names.
+type Resolver interface {
+	ResolveSegmentNames(tenant string, names []string) ([]string, error)
+}
+
+// CheckResolved is Check for segments that may be referred to by an alias:
+// access depends on the namespace of the segment the alias points to. The
+// *DeniedError lists the names as given. Other errors come from resolver.
+func CheckResolved(ctx context.Context, resolver Resolver, segments ...string) error {
+	p := FromContext(ctx)
+	if p == nil {
+		return nil
+	}
+
+	resolved, err := resolver.ResolveSegmentNames(Tenant(ctx), segments)
+	if err != nil {
+		return err
+	}
+
+	var denied []string
+	for i, segment := range segments {
+		if !p.Allows(Namespace(resolved[i])) {
+			denied = append(denied, segment)
+		}
+	}
+	if len(denied) > 0 {
+		return &DeniedError{Principal: p.Name, Segments: denied}
+	}
+
+	return nil
+}
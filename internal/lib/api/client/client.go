@@ -0,0 +1,57 @@
+package client
+
+import (
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	"github.com/go-chi/chi/v5/middleware"
+	"net"
+	"net/http"
+)
+
+const (
+	HeaderActor  = "X-Actor"
+	HeaderAPIKey = "X-API-Key"
+)
+
+const anonymous = "anonymous"
+
+type Actor struct {
+	Name      string
+	RequestID string
+	IP        string
+}
+
+// ActorFrom prefers the authenticated principal over the self-reported
+// X-Actor header.
+func ActorFrom(r *http.Request) Actor {
+	name := r.Header.Get(HeaderActor)
+	if p := access.FromContext(r.Context()); p != nil {
+		name = p.Name
+	}
+	if name == "" {
+		name = anonymous
+	}
+
+	return Actor{
+		Name:      name,
+		RequestID: middleware.GetReqID(r.Context()),
+		IP:        IP(r),
+	}
+}
+
+func IP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Key identifies the calling client: its authenticated principal, otherwise
+// its remote address. An unchecked X-API-Key is not used, a caller could
+// send a new one with every request.
+func Key(r *http.Request) string {
+	if p := access.FromContext(r.Context()); p != nil {
+		return "principal:" + p.Name
+	}
+	return "ip:" + IP(r)
+}
@@ -0,0 +1,41 @@
+package etag
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	HeaderETag    = "ETag"
+	HeaderIfMatch = "If-Match"
+)
+
+// ErrInvalid is returned for an If-Match that names no version, such as a
+// weak or foreign tag. It can never match.
+var ErrInvalid = errors.New("If-Match does not name a version")
+
+// Format renders a version as a strong entity tag.
+func Format(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// IfMatch returns the version required by the If-Match header of r, nil when
+// the header is absent or "*".
+func IfMatch(r *http.Request) (*int64, error) {
+	value := strings.TrimSpace(r.Header.Get(HeaderIfMatch))
+	if value == "" || value == "*" {
+		return nil, nil
+	}
+
+	if len(value) < 2 || !strings.HasPrefix(value, `"`) || !strings.HasSuffix(value, `"`) {
+		return nil, ErrInvalid
+	}
+	version, err := strconv.ParseInt(value[1:len(value)-1], 10, 64)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	return &version, nil
+}
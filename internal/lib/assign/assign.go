@@ -0,0 +1,33 @@
+package assign
+
+import (
+	"hash/fnv"
+	"strconv"
+)
+
+// Variant deterministically picks an index of weights for the user: the same
+// experiment and user always get the same variant, and over many users the
+// variants are hit proportionally to their weights.
+func Variant(experiment string, userId int64, weights []int) int {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	if total <= 0 {
+		return 0
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(experiment))
+	h.Write([]byte{':'})
+	h.Write([]byte(strconv.FormatInt(userId, 10)))
+	point := int(h.Sum64() % uint64(total))
+
+	for i, w := range weights {
+		if point < w {
+			return i
+		}
+		point -= w
+	}
+	return len(weights) - 1
+}
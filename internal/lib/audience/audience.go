@@ -0,0 +1,91 @@
+// Package audience implements boolean queries over segment membership, for
+// example
+//
+//	(VOICE_MESSAGES or PERFORMANCE_VAS) and not DISCOUNT_50
+//
+// A query is compiled to a single SQL condition, so it is evaluated by the
+// database rather than user by user.
+package audience
+
+import (
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/boolexpr"
+	"unicode"
+)
+
+type Expr interface {
+	// SQL renders the expression as a condition on a user, member renders
+	// the condition of being in a segment.
+	SQL(member func(segment string) string) string
+	String() string
+	segments(add func(segment string))
+}
+
+type and struct{ left, right Expr }
+
+func (e and) SQL(member func(string) string) string {
+	return "(" + e.left.SQL(member) + " AND " + e.right.SQL(member) + ")"
+}
+func (e and) String() string            { return "(" + e.left.String() + " and " + e.right.String() + ")" }
+func (e and) segments(add func(string)) { e.left.segments(add); e.right.segments(add) }
+
+type or struct{ left, right Expr }
+
+func (e or) SQL(member func(string) string) string {
+	return "(" + e.left.SQL(member) + " OR " + e.right.SQL(member) + ")"
+}
+func (e or) String() string            { return "(" + e.left.String() + " or " + e.right.String() + ")" }
+func (e or) segments(add func(string)) { e.left.segments(add); e.right.segments(add) }
+
+type not struct{ expr Expr }
+
+func (e not) SQL(member func(string) string) string { return "NOT " + e.expr.SQL(member) }
+func (e not) String() string                        { return "not " + e.expr.String() }
+func (e not) segments(add func(string))             { e.expr.segments(add) }
+
+type segment struct{ name string }
+
+func (e segment) SQL(member func(string) string) string { return member(e.name) }
+func (e segment) String() string                        { return e.name }
+func (e segment) segments(add func(string))             { add(e.name) }
+
+// Segments returns the segments the expression refers to, each once, in the
+// order they first appear.
+func Segments(e Expr) []string {
+	var names []string
+	seen := make(map[string]struct{})
+	e.segments(func(name string) {
+		if _, ok := seen[name]; ok {
+			return
+		}
+		seen[name] = struct{}{}
+		names = append(names, name)
+	})
+	return names
+}
+
+var language = boolexpr.Language[Expr]{
+	Operand:     "segment",
+	IsIdentRune: isIdentRune,
+	And:         func(left, right Expr) Expr { return and{left: left, right: right} },
+	Or:          func(left, right Expr) Expr { return or{left: left, right: right} },
+	Not:         func(expr Expr) Expr { return not{expr: expr} },
+	ParseOperand: func(p *boolexpr.Parser[Expr], t boolexpr.Token) (Expr, error) {
+		return segment{name: t.Value}, nil
+	},
+}
+
+func Parse(src string) (Expr, error) {
+	expr, err := boolexpr.Parse(src, language)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+
+	return expr, nil
+}
+
+// isIdentRune accepts the runes of segment slugs, including the namespace
+// separator.
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-' || r == ':' || r == '/'
+}
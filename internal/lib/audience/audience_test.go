@@ -0,0 +1,126 @@
+package audience
+
+import (
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/boolexpr"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "a", want: "a"},
+		{query: "a or b and c", want: "(a or (b and c))"},
+		{query: "(a or b) and c", want: "((a or b) and c)"},
+		{query: "a and b or c and d", want: "((a and b) or (c and d))"},
+		{query: "not a and b", want: "(not a and b)"},
+		{query: "not (a and b)", want: "not (a and b)"},
+		{query: "not not a", want: "not not a"},
+		{query: "a and b and c", want: "((a and b) and c)"},
+
+		// Keywords are case-insensitive, segment slugs keep their case.
+		{query: "VOICE_MESSAGES AND NOT messenger/DISCOUNT_50", want: "(VOICE_MESSAGES and not messenger/DISCOUNT_50)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := expr.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQL(t *testing.T) {
+	member := func(segment string) string { return "in(" + segment + ")" }
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "a", want: "in(a)"},
+		{query: "a or b and not c", want: "(in(a) OR (in(b) AND NOT in(c)))"},
+		{query: "(a or b) and not c", want: "((in(a) OR in(b)) AND NOT in(c))"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := expr.SQL(member); got != tt.want {
+				t.Errorf("SQL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSegments(t *testing.T) {
+	expr, err := Parse("(b or a) and not b or messenger/c")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := []string{"b", "a", "messenger/c"}
+	if got := Segments(expr); !reflect.DeepEqual(got, want) {
+		t.Errorf("Segments() = %v, want %v", got, want)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"a and",
+		"or a",
+		"a or or b",
+		"not",
+		"(a or b",
+		"a or b)",
+		"()",
+		"a b",
+		"a = b",
+		"'a'",
+		"a and @",
+		"and",
+	}
+	for _, query := range tests {
+		t.Run(query, func(t *testing.T) {
+			if _, err := Parse(query); err == nil {
+				t.Errorf("Parse(%q) succeeded, want an error", query)
+			}
+		})
+	}
+}
+
+func TestParseTooDeep(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		ok    bool
+	}{
+		{name: "parentheses at the limit", query: strings.Repeat("(", boolexpr.MaxDepth) + "a" + strings.Repeat(")", boolexpr.MaxDepth), ok: true},
+		{name: "not at the limit", query: strings.Repeat("not ", boolexpr.MaxDepth) + "a", ok: true},
+		{name: "parentheses past the limit", query: strings.Repeat("(", boolexpr.MaxDepth+1) + "a" + strings.Repeat(")", boolexpr.MaxDepth+1)},
+		{name: "not past the limit", query: strings.Repeat("not ", boolexpr.MaxDepth+1) + "a"},
+		{name: "unclosed parentheses", query: strings.Repeat("(", 100000) + "a"},
+		{name: "repeated not", query: strings.Repeat("not ", 100000) + "a"},
+		{name: "mixed", query: strings.Repeat("not (", 50000) + "a" + strings.Repeat(")", 50000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			if tt.ok && err != nil {
+				t.Errorf("Parse() error = %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("Parse() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"encoding/json"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/access"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/client"
+	resp "github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/api/response"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"golang.org/x/exp/slog"
+	"net/http"
+)
+
+const (
+	ActionUserSave         = "user.save"
+	ActionUserDelete       = "user.delete"
+	ActionUserAttributes   = "user.attributes"
+	ActionUserSegmentsSet  = "user.segments.set"
+	ActionSegmentSave      = "segment.save"
+	ActionSegmentDelete    = "segment.delete"
+	ActionSegmentAddToUser = "segment.addToUser"
+	ActionSegmentRestore   = "segment.restore"
+	ActionSegmentPurge     = "segment.purge"
+	ActionSegmentState     = "segment.state"
+	ActionSegmentUpdate    = "segment.update"
+	ActionSegmentRename    = "segment.rename"
+	ActionSegmentCompose   = "segment.compose"
+	ActionSegmentClone     = "segment.clone"
+	ActionAliasRetire      = "segment.alias.retire"
+	ActionGroupSave        = "segment.group.save"
+	ActionGroupDelete      = "segment.group.delete"
+	ActionExperimentSave   = "experiment.save"
+	ActionExperimentDelete = "experiment.delete"
+	ActionWebhookSave      = "webhook.save"
+	ActionWebhookDelete    = "webhook.delete"
+	ActionWebhookRedeliver = "webhook.redeliver"
+	ActionDatasetImport    = "dataset.import"
+)
+
+type Recorder interface {
+	SaveAuditEntry(entry storage.AuditEntryDTO) error
+}
+
+// Record stores the outcome of a mutating request. Failing to write the
+// entry is logged but never fails the request itself.
+func Record(log *slog.Logger, recorder Recorder, r *http.Request, action string, payload any, opErr error) {
+	actor := client.ActorFrom(r)
+
+	entry := storage.AuditEntryDTO{
+		Tenant:    access.Tenant(r.Context()),
+		Actor:     actor.Name,
+		RequestID: actor.RequestID,
+		IP:        actor.IP,
+		Action:    action,
+		Result:    resp.StatusOK,
+	}
+
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			log.Error("failed to encode audit payload", sl.Err(err))
+		} else {
+			entry.Payload = raw
+		}
+	}
+
+	if opErr != nil {
+		entry.Result = resp.StatusError
+		entry.Error = opErr.Error()
+	}
+
+	if err := recorder.SaveAuditEntry(entry); err != nil {
+		log.Error("failed to save audit entry", sl.Err(err), slog.String("action", action))
+	}
+}
@@ -0,0 +1,170 @@
+// Package boolexpr parses boolean expressions built with and, or, not and
+// parentheses. not binds tighter than and, and tighter than or; keywords are
+// case-insensitive. What an operand looks like is up to the Language, so the
+// same parser serves segment rules and audience queries.
+package boolexpr
+
+import (
+	"fmt"
+)
+
+// MaxDepth limits how deeply not and parentheses may nest, so a hostile
+// expression cannot exhaust the stack of the recursive descent.
+const MaxDepth = 64
+
+// Language describes the operands of an expression and builds its nodes of
+// type E.
+type Language[E any] struct {
+	// Operand names what the language expects where an operand is missing,
+	// e.g. "attribute".
+	Operand string
+	// Keywords are reserved in addition to and, or and not.
+	Keywords    []string
+	IsIdentRune func(r rune) bool
+
+	And func(left, right E) E
+	Or  func(left, right E) E
+	Not func(expr E) E
+	// ParseOperand parses an operand starting with the identifier t, which
+	// is not a keyword. It reads further tokens from p.
+	ParseOperand func(p *Parser[E], t Token) (E, error)
+}
+
+func Parse[E any](src string, lang Language[E]) (E, error) {
+	var zero E
+
+	tokens, err := Tokenize(src, lang.IsIdentRune)
+	if err != nil {
+		return zero, err
+	}
+
+	p := &Parser[E]{lang: lang, tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return zero, err
+	}
+	if p.Peek().Kind != EOF {
+		return zero, fmt.Errorf("unexpected %s", p.Peek())
+	}
+
+	return expr, nil
+}
+
+type Parser[E any] struct {
+	lang   Language[E]
+	tokens []Token
+	pos    int
+	depth  int
+}
+
+func (p *Parser[E]) Peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *Parser[E]) Next() Token {
+	t := p.tokens[p.pos]
+	if t.Kind != EOF {
+		p.pos++
+	}
+	return t
+}
+
+// IsKeyword reports whether t is one of the reserved words of the language.
+func (p *Parser[E]) IsKeyword(t Token) bool {
+	if t.Is("and") || t.Is("or") || t.Is("not") {
+		return true
+	}
+	for _, keyword := range p.lang.Keywords {
+		if t.Is(keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// enter counts one more level of nesting and fails past MaxDepth. Every
+// successful call is paired with leave.
+func (p *Parser[E]) enter() error {
+	if p.depth == MaxDepth {
+		return fmt.Errorf("expression nested deeper than %d levels", MaxDepth)
+	}
+	p.depth++
+	return nil
+}
+
+func (p *Parser[E]) leave() {
+	p.depth--
+}
+
+func (p *Parser[E]) parseOr() (E, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return left, err
+	}
+	for p.Peek().Is("or") {
+		p.Next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return right, err
+		}
+		left = p.lang.Or(left, right)
+	}
+	return left, nil
+}
+
+func (p *Parser[E]) parseAnd() (E, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return left, err
+	}
+	for p.Peek().Is("and") {
+		p.Next()
+		right, err := p.parseNot()
+		if err != nil {
+			return right, err
+		}
+		left = p.lang.And(left, right)
+	}
+	return left, nil
+}
+
+func (p *Parser[E]) parseNot() (E, error) {
+	if p.Peek().Is("not") {
+		p.Next()
+		if err := p.enter(); err != nil {
+			var zero E
+			return zero, err
+		}
+		defer p.leave()
+		expr, err := p.parseNot()
+		if err != nil {
+			return expr, err
+		}
+		return p.lang.Not(expr), nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *Parser[E]) parsePrimary() (E, error) {
+	var zero E
+
+	t := p.Next()
+	switch {
+	case t.Kind == LParen:
+		if err := p.enter(); err != nil {
+			return zero, err
+		}
+		defer p.leave()
+		expr, err := p.parseOr()
+		if err != nil {
+			return expr, err
+		}
+		if closing := p.Next(); closing.Kind != RParen {
+			return zero, fmt.Errorf("expected \")\", got %s", closing)
+		}
+		return expr, nil
+	case t.Kind == Ident && !p.IsKeyword(t):
+		return p.lang.ParseOperand(p, t)
+	}
+	return zero, fmt.Errorf("expected %s, got %s", p.lang.Operand, t)
+}
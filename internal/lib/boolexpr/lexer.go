@@ -0,0 +1,105 @@
+package boolexpr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type Kind int
+
+const (
+	EOF Kind = iota
+	Ident
+	String
+	Op
+	LParen
+	RParen
+	LBracket
+	RBracket
+	Comma
+)
+
+type Token struct {
+	Kind  Kind
+	Value string
+	Pos   int
+}
+
+func (t Token) String() string {
+	if t.Kind == EOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q at %d", t.Value, t.Pos)
+}
+
+// Is reports whether t is the keyword, in any case.
+func (t Token) Is(keyword string) bool {
+	return t.Kind == Ident && strings.EqualFold(t.Value, keyword)
+}
+
+// Tokenize splits src into tokens. Identifiers are runs of runes accepted by
+// isIdentRune, strings are quoted with ' or ".
+func Tokenize(src string, isIdentRune func(r rune) bool) ([]Token, error) {
+	var tokens []Token
+
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, Token{Kind: LParen, Value: "(", Pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, Token{Kind: RParen, Value: ")", Pos: i})
+			i++
+		case r == '[':
+			tokens = append(tokens, Token{Kind: LBracket, Value: "[", Pos: i})
+			i++
+		case r == ']':
+			tokens = append(tokens, Token{Kind: RBracket, Value: "]", Pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, Token{Kind: Comma, Value: ",", Pos: i})
+			i++
+		case r == '=':
+			tokens = append(tokens, Token{Kind: Op, Value: "=", Pos: i})
+			i++
+		case r == '!' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected %q at %d", op, i)
+			}
+			tokens = append(tokens, Token{Kind: Op, Value: op, Pos: i})
+			i += len(op)
+		case r == '\'' || r == '"':
+			start := i
+			i++
+			var b strings.Builder
+			for i < len(runes) && runes[i] != r {
+				b.WriteRune(runes[i])
+				i++
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			tokens = append(tokens, Token{Kind: String, Value: b.String(), Pos: start})
+		case isIdentRune(r):
+			start := i
+			for i < len(runes) && isIdentRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, Token{Kind: Ident, Value: string(runes[start:i]), Pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", r, i)
+		}
+	}
+
+	return append(tokens, Token{Kind: EOF, Pos: len(runes)}), nil
+}
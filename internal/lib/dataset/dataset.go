@@ -0,0 +1,372 @@
+package dataset
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"io"
+	"time"
+)
+
+// Version of the archive format. Archives of other versions are rejected.
+const Version = 1
+
+// An archive is NDJSON: a header line, one line per record and a footer line
+// with the record counts, which tells a complete archive from a cut one.
+// Records go in dependency order: groups, segments, aliases, users, memberships.
+// Sources of a composed segment come before it.
+const (
+	KindHeader     = "header"
+	KindGroup      = "group"
+	KindSegment    = "segment"
+	KindAlias      = "alias"
+	KindUser       = "user"
+	KindMembership = "membership"
+	KindFooter     = "footer"
+)
+
+const maxLineSize = 16 << 20
+
+var ErrInvalid = errors.New("invalid archive")
+
+type Header struct {
+	Kind       string    `json:"kind"`
+	Version    int       `json:"version"`
+	Tenant     string    `json:"tenant"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+type Group struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Exclusive bool   `json:"exclusive"`
+}
+
+type Segment struct {
+	Kind        string       `json:"kind"`
+	Name        string       `json:"name"`
+	Rule        string       `json:"rule,omitempty"`
+	State       string       `json:"state"`
+	StartsAt    *time.Time   `json:"starts_at,omitempty"`
+	EndsAt      *time.Time   `json:"ends_at,omitempty"`
+	ArchivedAt  *time.Time   `json:"archived_at,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Owner       string       `json:"owner,omitempty"`
+	Contact     string       `json:"contact,omitempty"`
+	Tags        []string     `json:"tags,omitempty"`
+	Group       string       `json:"group,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	Composition *Composition `json:"composition,omitempty"`
+}
+
+type Composition struct {
+	Op      string   `json:"op"`
+	Sources []string `json:"sources"`
+	Live    bool     `json:"live,omitempty"`
+}
+
+type Alias struct {
+	Kind    string `json:"kind"`
+	Alias   string `json:"alias"`
+	Segment string `json:"segment"`
+}
+
+type User struct {
+	Kind       string         `json:"kind"`
+	ID         int64          `json:"id"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+type Membership struct {
+	Kind    string `json:"kind"`
+	UserID  int64  `json:"user_id"`
+	Segment string `json:"segment"`
+}
+
+type Footer struct {
+	Kind        string `json:"kind"`
+	Groups      int    `json:"groups"`
+	Segments    int    `json:"segments"`
+	Aliases     int    `json:"aliases"`
+	Users       int    `json:"users"`
+	Memberships int    `json:"memberships"`
+}
+
+// Write encodes the dataset of tenant as an archive.
+func Write(w io.Writer, tenant string, d *storage.DatasetDTO) error {
+	enc := json.NewEncoder(w)
+
+	err := enc.Encode(Header{Kind: KindHeader, Version: Version, Tenant: tenant, ExportedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	for _, group := range d.Groups {
+		if err := enc.Encode(Group{Kind: KindGroup, Name: group.Name, Exclusive: group.Exclusive}); err != nil {
+			return err
+		}
+	}
+	for _, segment := range d.Segments {
+		var composition *Composition
+		if segment.Composition != nil {
+			composition = &Composition{Op: segment.Composition.Op, Sources: segment.Composition.Sources, Live: segment.Composition.Live}
+		}
+		err := enc.Encode(Segment{
+			Kind:        KindSegment,
+			Name:        segment.Name,
+			Rule:        segment.Rule,
+			State:       segment.State,
+			StartsAt:    segment.StartsAt,
+			EndsAt:      segment.EndsAt,
+			ArchivedAt:  segment.ArchivedAt,
+			Description: segment.Description,
+			Owner:       segment.Owner,
+			Contact:     segment.Contact,
+			Tags:        segment.Tags,
+			Group:       segment.Group,
+			CreatedAt:   segment.CreatedAt,
+			UpdatedAt:   segment.UpdatedAt,
+			Composition: composition,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, alias := range d.Aliases {
+		if err := enc.Encode(Alias{Kind: KindAlias, Alias: alias.Alias, Segment: alias.Segment}); err != nil {
+			return err
+		}
+	}
+	for _, user := range d.Users {
+		if err := enc.Encode(User{Kind: KindUser, ID: user.ID, Attributes: user.Attributes}); err != nil {
+			return err
+		}
+	}
+	for _, membership := range d.Memberships {
+		if err := enc.Encode(Membership{Kind: KindMembership, UserID: membership.UserID, Segment: membership.Segment}); err != nil {
+			return err
+		}
+	}
+
+	return enc.Encode(Footer{
+		Kind:        KindFooter,
+		Groups:      len(d.Groups),
+		Segments:    len(d.Segments),
+		Aliases:     len(d.Aliases),
+		Users:       len(d.Users),
+		Memberships: len(d.Memberships),
+	})
+}
+
+// Read decodes and validates an archive. The archive must be self-contained:
+// every segment, group and user a record refers to is part of it. Errors
+// about the content wrap ErrInvalid.
+func Read(r io.Reader) (*Header, *storage.DatasetDTO, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	var (
+		header *Header
+		footer *Footer
+		line   int
+	)
+	d := storage.DatasetDTO{
+		Groups:      make([]storage.DatasetGroupDTO, 0),
+		Segments:    make([]storage.DatasetSegmentDTO, 0),
+		Aliases:     make([]storage.DatasetAliasDTO, 0),
+		Users:       make([]storage.DatasetUserDTO, 0),
+		Memberships: make([]storage.DatasetMembershipDTO, 0),
+	}
+	groups := make(map[string]struct{})
+	// Segments map to whether they have a rule.
+	segments := make(map[string]bool)
+	aliases := make(map[string]struct{})
+	users := make(map[int64]struct{})
+	memberships := make(map[storage.DatasetMembershipDTO]struct{})
+
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: line %d: %s", ErrInvalid, line, fmt.Sprintf(format, args...))
+	}
+
+	for scanner.Scan() {
+		line++
+		raw := scanner.Bytes()
+		if len(raw) == 0 {
+			continue
+		}
+
+		var kind struct {
+			Kind string `json:"kind"`
+		}
+		if err := json.Unmarshal(raw, &kind); err != nil {
+			return nil, nil, invalid("%s", err)
+		}
+		if header == nil && kind.Kind != KindHeader {
+			return nil, nil, invalid("archive must start with a header")
+		}
+		if footer != nil {
+			return nil, nil, invalid("record after the footer")
+		}
+
+		switch kind.Kind {
+		case KindHeader:
+			if header != nil {
+				return nil, nil, invalid("duplicate header")
+			}
+			header = &Header{}
+			if err := json.Unmarshal(raw, header); err != nil {
+				return nil, nil, invalid("%s", err)
+			}
+			if header.Version != Version {
+				return nil, nil, invalid("unsupported archive version %d", header.Version)
+			}
+		case KindGroup:
+			var group Group
+			if err := json.Unmarshal(raw, &group); err != nil {
+				return nil, nil, invalid("%s", err)
+			}
+			if group.Name == "" {
+				return nil, nil, invalid("group without name")
+			}
+			if _, ok := groups[group.Name]; ok {
+				return nil, nil, invalid("duplicate group %s", group.Name)
+			}
+			groups[group.Name] = struct{}{}
+			d.Groups = append(d.Groups, storage.DatasetGroupDTO{Name: group.Name, Exclusive: group.Exclusive})
+		case KindSegment:
+			var segment Segment
+			if err := json.Unmarshal(raw, &segment); err != nil {
+				return nil, nil, invalid("%s", err)
+			}
+			if segment.Name == "" {
+				return nil, nil, invalid("segment without name")
+			}
+			if _, ok := segments[segment.Name]; ok {
+				return nil, nil, invalid("duplicate segment %s", segment.Name)
+			}
+			switch segment.State {
+			case storage.SegmentStateDraft, storage.SegmentStateActive, storage.SegmentStatePaused, storage.SegmentStateArchived:
+			default:
+				return nil, nil, invalid("segment %s has unknown state %q", segment.Name, segment.State)
+			}
+			if segment.StartsAt != nil && segment.EndsAt != nil && !segment.EndsAt.After(*segment.StartsAt) {
+				return nil, nil, invalid("segment %s ends before it starts", segment.Name)
+			}
+			if _, ok := groups[segment.Group]; segment.Group != "" && !ok {
+				return nil, nil, invalid("segment %s refers to unknown group %s", segment.Name, segment.Group)
+			}
+			var composition *storage.SegmentCompositionDTO
+			if c := segment.Composition; c != nil {
+				switch c.Op {
+				case storage.SetOpUnion, storage.SetOpIntersection, storage.SetOpDifference:
+				default:
+					return nil, nil, invalid("segment %s has unknown set operation %q", segment.Name, c.Op)
+				}
+				if len(c.Sources) == 0 {
+					return nil, nil, invalid("segment %s is composed of no segments", segment.Name)
+				}
+				for _, source := range c.Sources {
+					ruleBased, ok := segments[source]
+					if !ok {
+						return nil, nil, invalid("segment %s is composed of unknown or later segment %s", segment.Name, source)
+					}
+					if ruleBased {
+						return nil, nil, invalid("segment %s is composed of rule segment %s", segment.Name, source)
+					}
+				}
+				composition = &storage.SegmentCompositionDTO{Op: c.Op, Sources: c.Sources, Live: c.Live}
+			}
+			segments[segment.Name] = segment.Rule != ""
+			d.Segments = append(d.Segments, storage.DatasetSegmentDTO{
+				Name:        segment.Name,
+				Rule:        segment.Rule,
+				State:       segment.State,
+				StartsAt:    segment.StartsAt,
+				EndsAt:      segment.EndsAt,
+				ArchivedAt:  segment.ArchivedAt,
+				Description: segment.Description,
+				Owner:       segment.Owner,
+				Contact:     segment.Contact,
+				Tags:        segment.Tags,
+				Group:       segment.Group,
+				CreatedAt:   segment.CreatedAt,
+				UpdatedAt:   segment.UpdatedAt,
+				Composition: composition,
+			})
+		case KindAlias:
+			var alias Alias
+			if err := json.Unmarshal(raw, &alias); err != nil {
+				return nil, nil, invalid("%s", err)
+			}
+			if alias.Alias == "" {
+				return nil, nil, invalid("alias without name")
+			}
+			if _, ok := aliases[alias.Alias]; ok {
+				return nil, nil, invalid("duplicate alias %s", alias.Alias)
+			}
+			if _, ok := segments[alias.Alias]; ok {
+				return nil, nil, invalid("alias %s is also a segment", alias.Alias)
+			}
+			if _, ok := segments[alias.Segment]; !ok {
+				return nil, nil, invalid("alias %s refers to unknown segment %s", alias.Alias, alias.Segment)
+			}
+			aliases[alias.Alias] = struct{}{}
+			d.Aliases = append(d.Aliases, storage.DatasetAliasDTO{Alias: alias.Alias, Segment: alias.Segment})
+		case KindUser:
+			var user User
+			if err := json.Unmarshal(raw, &user); err != nil {
+				return nil, nil, invalid("%s", err)
+			}
+			if user.ID <= 0 {
+				return nil, nil, invalid("invalid user id %d", user.ID)
+			}
+			if _, ok := users[user.ID]; ok {
+				return nil, nil, invalid("duplicate user %d", user.ID)
+			}
+			users[user.ID] = struct{}{}
+			d.Users = append(d.Users, storage.DatasetUserDTO{ID: user.ID, Attributes: user.Attributes})
+		case KindMembership:
+			var membership Membership
+			if err := json.Unmarshal(raw, &membership); err != nil {
+				return nil, nil, invalid("%s", err)
+			}
+			if _, ok := users[membership.UserID]; !ok {
+				return nil, nil, invalid("membership refers to unknown user %d", membership.UserID)
+			}
+			if _, ok := segments[membership.Segment]; !ok {
+				return nil, nil, invalid("membership refers to unknown segment %s", membership.Segment)
+			}
+			dto := storage.DatasetMembershipDTO{UserID: membership.UserID, Segment: membership.Segment}
+			if _, ok := memberships[dto]; ok {
+				return nil, nil, invalid("duplicate membership of user %d in %s", dto.UserID, dto.Segment)
+			}
+			memberships[dto] = struct{}{}
+			d.Memberships = append(d.Memberships, dto)
+		case KindFooter:
+			footer = &Footer{}
+			if err := json.Unmarshal(raw, footer); err != nil {
+				return nil, nil, invalid("%s", err)
+			}
+		default:
+			return nil, nil, invalid("unknown record kind %q", kind.Kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if header == nil {
+		return nil, nil, fmt.Errorf("%w: archive is empty", ErrInvalid)
+	}
+	if footer == nil {
+		return nil, nil, fmt.Errorf("%w: archive is truncated, footer is missing", ErrInvalid)
+	}
+	if footer.Groups != len(d.Groups) || footer.Segments != len(d.Segments) || footer.Aliases != len(d.Aliases) ||
+		footer.Users != len(d.Users) || footer.Memberships != len(d.Memberships) {
+		return nil, nil, fmt.Errorf("%w: record counts do not match the footer", ErrInvalid)
+	}
+
+	return header, &d, nil
+}
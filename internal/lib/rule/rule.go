@@ -0,0 +1,205 @@
+// Package rule implements the language of dynamic segment rules, for example
+//
+//	city in [Moscow, Kazan] and (platform = ios or not registered_at < 2023-01-01)
+//
+// A condition compares a user attribute with a literal. Values are compared as
+// numbers when both sides are numbers, as dates when both sides are dates and
+// as strings otherwise. A condition on a missing attribute is false.
+package rule
+
+import (
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/boolexpr"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type Expr interface {
+	Eval(attrs map[string]any) bool
+	String() string
+}
+
+type and struct{ left, right Expr }
+
+func (e and) Eval(attrs map[string]any) bool { return e.left.Eval(attrs) && e.right.Eval(attrs) }
+func (e and) String() string                 { return "(" + e.left.String() + " and " + e.right.String() + ")" }
+
+type or struct{ left, right Expr }
+
+func (e or) Eval(attrs map[string]any) bool { return e.left.Eval(attrs) || e.right.Eval(attrs) }
+func (e or) String() string                 { return "(" + e.left.String() + " or " + e.right.String() + ")" }
+
+type not struct{ expr Expr }
+
+func (e not) Eval(attrs map[string]any) bool { return !e.expr.Eval(attrs) }
+func (e not) String() string                 { return "not " + e.expr.String() }
+
+type condition struct {
+	attr   string
+	op     string
+	values []string
+}
+
+func (c condition) String() string {
+	if c.op == "in" {
+		return c.attr + " in [" + strings.Join(c.values, ", ") + "]"
+	}
+	return c.attr + " " + c.op + " " + c.values[0]
+}
+
+func (c condition) Eval(attrs map[string]any) bool {
+	raw, ok := attrs[c.attr]
+	if !ok || raw == nil {
+		return false
+	}
+	value := stringify(raw)
+
+	switch c.op {
+	case "in":
+		for _, v := range c.values {
+			if compare(value, v) == 0 {
+				return true
+			}
+		}
+		return false
+	case "=":
+		return compare(value, c.values[0]) == 0
+	case "!=":
+		return compare(value, c.values[0]) != 0
+	case "<":
+		return compare(value, c.values[0]) < 0
+	case "<=":
+		return compare(value, c.values[0]) <= 0
+	case ">":
+		return compare(value, c.values[0]) > 0
+	case ">=":
+		return compare(value, c.values[0]) >= 0
+	}
+	return false
+}
+
+func stringify(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+var dateLayouts = []string{time.RFC3339, "2006-01-02"}
+
+func parseDate(s string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func compare(a, b string) int {
+	if x, err := strconv.ParseFloat(a, 64); err == nil {
+		if y, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if x, ok := parseDate(a); ok {
+		if y, ok := parseDate(b); ok {
+			return x.Compare(y)
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+var language = boolexpr.Language[Expr]{
+	Operand:      "attribute",
+	Keywords:     []string{"in"},
+	IsIdentRune:  isIdentRune,
+	And:          func(left, right Expr) Expr { return and{left: left, right: right} },
+	Or:           func(left, right Expr) Expr { return or{left: left, right: right} },
+	Not:          func(expr Expr) Expr { return not{expr: expr} },
+	ParseOperand: parseCondition,
+}
+
+func Parse(src string) (Expr, error) {
+	expr, err := boolexpr.Parse(src, language)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule: %w", err)
+	}
+
+	return expr, nil
+}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-' || r == ':' || r == '+'
+}
+
+func parseCondition(p *boolexpr.Parser[Expr], attr boolexpr.Token) (Expr, error) {
+	t := p.Next()
+	switch {
+	case t.Kind == boolexpr.Op:
+		value, err := parseLiteral(p)
+		if err != nil {
+			return nil, err
+		}
+		return condition{attr: attr.Value, op: t.Value, values: []string{value}}, nil
+	case t.Is("in"):
+		values, err := parseList(p)
+		if err != nil {
+			return nil, err
+		}
+		return condition{attr: attr.Value, op: "in", values: values}, nil
+	case t.Is("not") && p.Peek().Is("in"):
+		p.Next()
+		values, err := parseList(p)
+		if err != nil {
+			return nil, err
+		}
+		return not{expr: condition{attr: attr.Value, op: "in", values: values}}, nil
+	}
+	return nil, fmt.Errorf("expected operator after %q, got %s", attr.Value, t)
+}
+
+func parseList(p *boolexpr.Parser[Expr]) ([]string, error) {
+	if t := p.Next(); t.Kind != boolexpr.LBracket {
+		return nil, fmt.Errorf("expected \"[\", got %s", t)
+	}
+
+	var values []string
+	for {
+		value, err := parseLiteral(p)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		t := p.Next()
+		if t.Kind == boolexpr.RBracket {
+			return values, nil
+		}
+		if t.Kind != boolexpr.Comma {
+			return nil, fmt.Errorf("expected \",\" or \"]\", got %s", t)
+		}
+	}
+}
+
+func parseLiteral(p *boolexpr.Parser[Expr]) (string, error) {
+	t := p.Next()
+	if t.Kind == boolexpr.String || (t.Kind == boolexpr.Ident && !p.IsKeyword(t)) {
+		return t.Value, nil
+	}
+	return "", fmt.Errorf("expected value, got %s", t)
+}
@@ -0,0 +1,156 @@
+package rule
+
+import (
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/boolexpr"
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	attrs := map[string]any{
+		"city":          "Moscow",
+		"platform":      "ios",
+		"age":           float64(27),
+		"premium":       true,
+		"registered_at": "2022-11-05",
+		"version":       "10.2",
+	}
+
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		{rule: "city = Moscow", want: true},
+		{rule: "city = 'Moscow'", want: true},
+		{rule: "city != Moscow", want: false},
+		{rule: "city in [Kazan, Moscow]", want: true},
+		{rule: "city in [Kazan, Samara]", want: false},
+		{rule: "city not in [Kazan, Samara]", want: true},
+		{rule: "city not in [Moscow]", want: false},
+
+		// Numbers compare as numbers, not as strings.
+		{rule: "age > 9", want: true},
+		{rule: "age >= 27", want: true},
+		{rule: "age < 27", want: false},
+		{rule: "age <= 27.0", want: true},
+		{rule: "version > 9", want: true},
+
+		// Dates compare as dates.
+		{rule: "registered_at < 2023-01-01", want: true},
+		{rule: "registered_at > 2022-11-05T00:00:00Z", want: false},
+		{rule: "registered_at >= 2022-11-05", want: true},
+
+		// Everything else compares as strings.
+		{rule: "platform < web", want: true},
+		{rule: "premium = true", want: true},
+
+		// A condition on a missing attribute is false, its negation true.
+		{rule: "country = RU", want: false},
+		{rule: "country != RU", want: false},
+		{rule: "country in [RU]", want: false},
+		{rule: "not country = RU", want: true},
+		{rule: "country not in [RU]", want: true},
+
+		// and binds tighter than or, not tighter than and.
+		{rule: "city = Kazan and platform = ios or age > 18", want: true},
+		{rule: "city = Kazan and (platform = ios or age > 18)", want: false},
+		{rule: "age > 18 or city = Kazan and platform = android", want: true},
+		{rule: "not city = Kazan and platform = ios", want: true},
+		{rule: "not (city = Moscow and platform = ios)", want: false},
+		{rule: "not not premium = true", want: true},
+
+		// Keywords are case-insensitive.
+		{rule: "city IN [Moscow] AND NOT platform = web", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			expr, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := expr.Eval(attrs); got != tt.want {
+				t.Errorf("Eval() = %v, want %v (parsed as %s)", got, tt.want, expr)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{rule: "a = 1 or b = 2 and c = 3", want: "(a = 1 or (b = 2 and c = 3))"},
+		{rule: "(a = 1 or b = 2) and c = 3", want: "((a = 1 or b = 2) and c = 3)"},
+		{rule: "not a = 1 and b in [x, y]", want: "(not a = 1 and b in [x, y])"},
+		{rule: "a not in [x]", want: "not a in [x]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			expr, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := expr.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"city",
+		"city =",
+		"= Moscow",
+		"city = Moscow and",
+		"city = Moscow or or age > 1",
+		"(city = Moscow",
+		"city = Moscow)",
+		"city in Moscow",
+		"city in [Moscow",
+		"city in [Moscow,]",
+		"city in []",
+		"city not = Moscow",
+		"city ! Moscow",
+		"city = 'Moscow",
+		"city = Moscow platform = ios",
+		"and = 1",
+		"city = @",
+	}
+	for _, rule := range tests {
+		t.Run(rule, func(t *testing.T) {
+			if _, err := Parse(rule); err == nil {
+				t.Errorf("Parse(%q) succeeded, want an error", rule)
+			}
+		})
+	}
+}
+
+func TestParseTooDeep(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		ok   bool
+	}{
+		{name: "parentheses at the limit", rule: strings.Repeat("(", boolexpr.MaxDepth) + "city = Moscow" + strings.Repeat(")", boolexpr.MaxDepth), ok: true},
+		{name: "not at the limit", rule: strings.Repeat("not ", boolexpr.MaxDepth) + "city = Moscow", ok: true},
+		{name: "parentheses past the limit", rule: strings.Repeat("(", boolexpr.MaxDepth+1) + "city = Moscow" + strings.Repeat(")", boolexpr.MaxDepth+1)},
+		{name: "not past the limit", rule: strings.Repeat("not ", boolexpr.MaxDepth+1) + "city = Moscow"},
+		{name: "unclosed parentheses", rule: strings.Repeat("(", 100000) + "city = Moscow"},
+		{name: "repeated not", rule: strings.Repeat("not ", 100000) + "city = Moscow"},
+		{name: "mixed", rule: strings.Repeat("not (", 50000) + "city = Moscow" + strings.Repeat(")", 50000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.rule)
+			if tt.ok && err != nil {
+				t.Errorf("Parse() error = %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("Parse() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package pubsub
+
+import (
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"sync"
+)
+
+const bufferSize = 64
+
+// topic is a user of a tenant, user ids alone are not unique across tenants.
+type topic struct {
+	tenant string
+	userId int64
+}
+
+type subscriber struct {
+	ch chan storage.EventDTO
+}
+
+// Broker fans membership events out to subscribers of the affected user.
+// A subscriber that cannot keep up is dropped: its channel is closed and the
+// client is expected to reconnect and catch up from the outbox.
+type Broker struct {
+	mu   sync.RWMutex
+	subs map[topic]map[*subscriber]struct{}
+}
+
+func New() *Broker {
+	return &Broker{
+		subs: make(map[topic]map[*subscriber]struct{}),
+	}
+}
+
+func (b *Broker) Subscribe(tenant string, userId int64) (<-chan storage.EventDTO, func()) {
+	sub := &subscriber{ch: make(chan storage.EventDTO, bufferSize)}
+	t := topic{tenant: tenant, userId: userId}
+
+	b.mu.Lock()
+	if b.subs[t] == nil {
+		b.subs[t] = make(map[*subscriber]struct{})
+	}
+	b.subs[t][sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.remove(t, sub)
+		})
+	}
+
+	return sub.ch, cancel
+}
+
+func (b *Broker) Publish(events ...storage.EventDTO) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, event := range events {
+		t := topic{tenant: event.Tenant, userId: event.UserID}
+		for sub := range b.subs[t] {
+			select {
+			case sub.ch <- event:
+			default:
+				b.remove(t, sub)
+			}
+		}
+	}
+}
+
+func (b *Broker) remove(t topic, sub *subscriber) {
+	subs, ok := b.subs[t]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+
+	delete(subs, sub)
+	close(sub.ch)
+	if len(subs) == 0 {
+		delete(b.subs, t)
+	}
+}
@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"context"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/config"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"golang.org/x/exp/slog"
+	"time"
+)
+
+type SegmentArchiver interface {
+	ArchiveEndedSegments() ([]string, error)
+}
+
+type Scheduler struct {
+	log      *slog.Logger
+	cfg      config.Scheduler
+	archiver SegmentArchiver
+}
+
+func New(log *slog.Logger, cfg config.Scheduler, archiver SegmentArchiver) *Scheduler {
+	return &Scheduler{
+		log:      log.With(slog.String("component", "scheduler")),
+		cfg:      cfg,
+		archiver: archiver,
+	}
+}
+
+func (s *Scheduler) Run(ctx context.Context) {
+	s.log.Info("scheduler started", slog.String("interval", s.cfg.Interval.String()))
+
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.log.Info("scheduler stopped")
+			return
+		case <-ticker.C:
+			s.archiveEndedSegments()
+		}
+	}
+}
+
+func (s *Scheduler) archiveEndedSegments() {
+	archived, err := s.archiver.ArchiveEndedSegments()
+	if err != nil {
+		s.log.Error("failed to archive ended segments", sl.Err(err))
+		return
+	}
+	if len(archived) > 0 {
+		s.log.Info("ended segments archived", slog.Any("segments", archived))
+	}
+}
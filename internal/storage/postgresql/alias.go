@@ -0,0 +1,186 @@
+package postgresql
+
+import (
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/lib/pq"
+	"golang.org/x/exp/slog"
+)
+
+// aliasHits counts lookups by a retired segment name, keyed by alias.
+var aliasHits = expvar.NewMap("segment_alias_hits")
+
+type resolvedSegment struct {
+	id    int64
+	name  string
+	state string
+	// computed segments are live compositions, their members can not be
+	// changed directly.
+	computed bool
+	// ruleBased segments are matched on lookup and have no stored members.
+	ruleBased bool
+}
+
+// resolveSegment finds a segment by its name or by one of its aliases.
+// Alias lookups are counted so that aliases nobody uses can be retired.
+func (s *Storage) resolveSegment(q querier, tenant string, name string) (*resolvedSegment, error) {
+	const op = "storage.postgresql.resolveSegment"
+
+	segment := resolvedSegment{name: name}
+	err := q.QueryRow("SELECT id, state, composition_live, rule IS NOT NULL FROM segments WHERE tenant = $1 AND name = $2", tenant, name).
+		Scan(&segment.id, &segment.state, &segment.computed, &segment.ruleBased)
+	if err == nil {
+		return &segment, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = q.QueryRow(`UPDATE segment_aliases SET usage_count = usage_count + 1, last_used_at = now()
+		FROM segments WHERE segment_aliases.segment_id = segments.id
+		  AND segment_aliases.tenant = $1 AND segment_aliases.alias = $2
+		RETURNING segments.id, segments.name, segments.state, segments.composition_live, segments.rule IS NOT NULL`, tenant, name).
+		Scan(&segment.id, &segment.name, &segment.state, &segment.computed, &segment.ruleBased)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrSegmentNotFound
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	aliasHits.Add(tenant+"/"+name, 1)
+	s.log.Warn("segment resolved by alias", slog.String("tenant", tenant), slog.String("alias", name), slog.String("segment", segment.name))
+
+	return &segment, nil
+}
+
+// ResolveSegmentNames returns the current name for each of names, following
+// aliases. Unknown names are returned as they are. Unlike resolveSegment it
+// does not count alias usage, it is meant for access checks.
+func (s *Storage) ResolveSegmentNames(tenant string, names []string) ([]string, error) {
+	const op = "storage.postgresql.ResolveSegmentNames"
+
+	resolved := make([]string, 0, len(names))
+	err := scanAll(s.db, func(rows *sql.Rows) error {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		resolved = append(resolved, name)
+		return nil
+	}, `SELECT COALESCE(segments.name, aliased.name, n.name)
+		FROM unnest($2::text[]) WITH ORDINALITY AS n(name, ord)
+		LEFT JOIN segments ON segments.tenant = $1 AND segments.name = n.name
+		LEFT JOIN segment_aliases ON segment_aliases.tenant = $1 AND segment_aliases.alias = n.name
+		LEFT JOIN segments AS aliased ON aliased.id = segment_aliases.segment_id
+		ORDER BY n.ord`, tenant, pq.Array(names))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return resolved, nil
+}
+
+// RenameSegment changes the segment name and keeps the old one as an alias.
+func (s *Storage) RenameSegment(tenant string, name string, newName string) error {
+	const op = "storage.postgresql.RenameSegment"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var segmentId int64
+	err = tx.QueryRow("SELECT id FROM segments WHERE tenant = $1 AND name = $2 FOR UPDATE", tenant, name).Scan(&segmentId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrSegmentNotFound
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Renaming back to a former name turns the alias into the name again.
+	var aliasOf int64
+	err = tx.QueryRow("DELETE FROM segment_aliases WHERE tenant = $1 AND alias = $2 RETURNING segment_id", tenant, newName).Scan(&aliasOf)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return fmt.Errorf("%s: %w", op, err)
+	case aliasOf != segmentId:
+		return storage.ErrSegmentExists
+	}
+
+	_, err = tx.Exec("UPDATE segments SET name = $2, updated_at = now() WHERE id = $1", segmentId, newName)
+	if err != nil {
+		if isUniqueViolation(err, "segments_tenant_name_key") {
+			return storage.ErrSegmentExists
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.Exec("INSERT INTO segment_aliases(tenant, alias, segment_id) VALUES($1, $2, $3)", tenant, name, segmentId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RetireSegmentAlias(tenant string, alias string) error {
+	const op = "storage.postgresql.RetireSegmentAlias"
+
+	res, err := s.db.Exec("DELETE FROM segment_aliases WHERE tenant = $1 AND alias = $2", tenant, alias)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	retired, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if retired == 0 {
+		return storage.ErrAliasNotFound
+	}
+
+	return nil
+}
+
+// GetSegmentAliases lists aliases of the segment, or all of them when
+// segment is empty.
+func (s *Storage) GetSegmentAliases(tenant string, segment string) ([]storage.SegmentAliasDTO, error) {
+	const op = "storage.postgresql.GetSegmentAliases"
+
+	rows, err := s.db.Query(`SELECT segment_aliases.alias, segments.name, segment_aliases.usage_count,
+		segment_aliases.last_used_at, segment_aliases.created_at
+		FROM segment_aliases JOIN segments ON segment_aliases.segment_id = segments.id
+		WHERE segment_aliases.tenant = $1 AND ($2::text = '' OR segments.name = $2)
+		ORDER BY segments.name, segment_aliases.alias`, tenant, segment)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	aliases := make([]storage.SegmentAliasDTO, 0)
+	for rows.Next() {
+		var alias storage.SegmentAliasDTO
+		err := rows.Scan(&alias.Alias, &alias.Segment, &alias.UsageCount, &alias.LastUsedAt, &alias.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		aliases = append(aliases, alias)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return aliases, nil
+}
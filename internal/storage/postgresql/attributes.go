@@ -0,0 +1,127 @@
+package postgresql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/rule"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+)
+
+// SaveUserAttributes merges attrs into the user's attributes, a null value
+// removes the attribute. With replace the attributes are overwritten.
+func (s *Storage) SaveUserAttributes(tenant string, userId int64, attrs map[string]any, replace bool) (*storage.UserAttributesDTO, error) {
+	const op = "storage.postgresql.SaveUserAttributes"
+
+	raw, err := json.Marshal(attrs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := "UPDATE users SET attributes = jsonb_strip_nulls(attributes || $3::jsonb) WHERE tenant = $1 AND id = $2 RETURNING attributes"
+	if replace {
+		query = "UPDATE users SET attributes = jsonb_strip_nulls($3::jsonb) WHERE tenant = $1 AND id = $2 RETURNING attributes"
+	}
+
+	var saved []byte
+	err = s.db.QueryRow(query, tenant, userId, string(raw)).Scan(&saved)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrUserNotFound
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	result := storage.UserAttributesDTO{UserID: userId}
+	if err := json.Unmarshal(saved, &result.Attributes); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &result, nil
+}
+
+func (s *Storage) GetUserAttributes(tenant string, userId int64) (map[string]any, error) {
+	const op = "storage.postgresql.GetUserAttributes"
+
+	var raw []byte
+	err := s.db.QueryRow("SELECT attributes FROM users WHERE tenant = $1 AND id = $2", tenant, userId).Scan(&raw)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrUserNotFound
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var attrs map[string]any
+	if err := json.Unmarshal(raw, &attrs); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return attrs, nil
+}
+
+func (s *Storage) GetRuleSegments(tenant string) ([]storage.RuleSegmentDTO, error) {
+	const op = "storage.postgresql.GetRuleSegments"
+
+	rows, err := s.db.Query("SELECT id, name, rule FROM segments WHERE tenant = $1 AND rule IS NOT NULL AND "+segmentVisible, tenant)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	segments := make([]storage.RuleSegmentDTO, 0)
+	for rows.Next() {
+		var segment storage.RuleSegmentDTO
+		err := rows.Scan(&segment.ID, &segment.Name, &segment.Rule)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		segments = append(segments, segment)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return segments, nil
+}
+
+// matchRuleSegments returns the rule segments matching the user's attributes
+// that are not among the explicit memberships already.
+func (s *Storage) matchRuleSegments(tenant string, userId int64, explicit []storage.SegmentDTO) ([]storage.SegmentDTO, error) {
+	attrs, err := s.GetUserAttributes(tenant, userId)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ruleSegments, err := s.GetRuleSegments(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	present := make(map[int64]struct{}, len(explicit))
+	for _, segment := range explicit {
+		present[segment.ID] = struct{}{}
+	}
+
+	var matched []storage.SegmentDTO
+	for _, segment := range ruleSegments {
+		if _, ok := present[segment.ID]; ok {
+			continue
+		}
+		// Rules are validated on save, a broken one never matches.
+		expr, err := rule.Parse(segment.Rule)
+		if err != nil {
+			continue
+		}
+		if expr.Eval(attrs) {
+			matched = append(matched, segment.SegmentDTO)
+		}
+	}
+
+	return matched, nil
+}
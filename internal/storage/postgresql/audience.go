@@ -0,0 +1,70 @@
+package postgresql
+
+import (
+	"errors"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/audience"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/lib/pq"
+	"strconv"
+)
+
+const defaultAudienceLimit = 100
+
+// QueryAudience counts the users matching expr and returns a page of their
+// ids, none when countOnly is set. The expression is compiled to one query
+// with an EXISTS subquery per segment; only explicit memberships of visible
+// segments count. Rule segments have none and are rejected with
+// storage.ErrSegmentRuleBased.
+func (s *Storage) QueryAudience(tenant string, expr audience.Expr, countOnly bool, limit int, offset int) (*storage.AudienceDTO, error) {
+	const op = "storage.postgresql.QueryAudience"
+
+	if countOnly {
+		limit = 0
+	} else if limit <= 0 {
+		limit = defaultAudienceLimit
+	}
+
+	// $1 is the tenant, $2 and $3 the page, segment ids follow.
+	args := []any{tenant, limit, offset}
+	params := make(map[string]string)
+	for _, name := range audience.Segments(expr) {
+		segment, err := s.resolveSegment(s.db, tenant, name)
+		if err != nil {
+			if errors.Is(err, storage.ErrSegmentNotFound) {
+				return nil, fmt.Errorf("%w: %s", storage.ErrSegmentNotFound, name)
+			}
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if segment.ruleBased {
+			return nil, fmt.Errorf("%w: %s", storage.ErrSegmentRuleBased, name)
+		}
+		args = append(args, segment.id)
+		params[name] = "$" + strconv.Itoa(len(args))
+	}
+
+	cond := expr.SQL(func(name string) string {
+		return `EXISTS (SELECT 1 FROM user_segments JOIN segments ON segments.id = user_segments.segment_id
+			WHERE user_segments.tenant = $1 AND user_segments.user_id = users.id
+			  AND user_segments.segment_id = ` + params[name] + ` AND ` + segmentVisible + `)`
+	})
+
+	var (
+		result storage.AudienceDTO
+		users  []int64
+	)
+	err := s.db.QueryRow(`WITH matched AS (SELECT id FROM users WHERE tenant = $1 AND `+cond+`)
+		SELECT (SELECT count(*) FROM matched),
+		       (SELECT array_agg(id ORDER BY id) FROM (SELECT id FROM matched ORDER BY id LIMIT $2 OFFSET $3) AS page)`,
+		args...).Scan(&result.Count, pq.Array(&users))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !countOnly {
+		result.Users = make([]int64, 0, len(users))
+		result.Users = append(result.Users, users...)
+	}
+
+	return &result, nil
+}
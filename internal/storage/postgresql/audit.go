@@ -0,0 +1,101 @@
+package postgresql
+
+import (
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"strings"
+)
+
+const defaultAuditLimit = 100
+
+func (s *Storage) SaveAuditEntry(entry storage.AuditEntryDTO) error {
+	const op = "storage.postgresql.SaveAuditEntry"
+
+	stmt, err := s.db.Prepare("INSERT INTO audit_log(tenant, actor, request_id, ip, action, payload, result, error) VALUES($1,$2,$3,$4,$5,$6,$7,$8)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	var payload any
+	if len(entry.Payload) > 0 {
+		payload = string(entry.Payload)
+	}
+
+	_, err = stmt.Exec(entry.Tenant, entry.Actor, entry.RequestID, entry.IP, entry.Action, payload, entry.Result, entry.Error)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetAuditEntries(tenant string, filter storage.AuditFilterDTO) ([]storage.AuditEntryDTO, error) {
+	const op = "storage.postgresql.GetAuditEntries"
+
+	var (
+		conds []string
+		args  []any
+	)
+	addCond := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	addCond("tenant = $%d", tenant)
+	if filter.Actor != "" {
+		addCond("actor = $%d", filter.Actor)
+	}
+	if filter.Action != "" {
+		addCond("action = $%d", filter.Action)
+	}
+	if filter.RequestID != "" {
+		addCond("request_id = $%d", filter.RequestID)
+	}
+	if filter.Result != "" {
+		addCond("result = $%d", filter.Result)
+	}
+	if filter.From != nil {
+		addCond("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addCond("created_at < $%d", *filter.To)
+	}
+
+	query := "SELECT id, tenant, actor, request_id, ip, action, COALESCE(payload::text, ''), result, error, created_at FROM audit_log"
+	query += " WHERE " + strings.Join(conds, " AND ")
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	args = append(args, limit, filter.Offset)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	entries := make([]storage.AuditEntryDTO, 0)
+	for rows.Next() {
+		var (
+			entry   storage.AuditEntryDTO
+			payload string
+		)
+		err := rows.Scan(&entry.ID, &entry.Tenant, &entry.Actor, &entry.RequestID, &entry.IP, &entry.Action, &payload, &entry.Result, &entry.Error, &entry.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if payload != "" {
+			entry.Payload = []byte(payload)
+		}
+		entries = append(entries, entry)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return entries, nil
+}
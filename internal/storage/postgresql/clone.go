@@ -0,0 +1,78 @@
+package postgresql
+
+import (
+	"errors"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/lib/pq"
+)
+
+// CloneSegment creates segment newName with the metadata of segment name and
+// copies its memberships, or a random sample of percent of them. The clone
+// is a plain segment: it belongs to no group, is not composed of other
+// segments, and a clone of an archived segment starts as a draft.
+func (s *Storage) CloneSegment(tenant string, name string, newName string, percent int) (*storage.ClonedSegmentDTO, error) {
+	const op = "storage.postgresql.CloneSegment"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	source, err := s.resolveSegment(tx, tenant, name)
+	if err != nil {
+		if errors.Is(err, storage.ErrSegmentNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var (
+		newSegment = storage.NewSegmentDTO{Name: newName}
+		rule       *string
+	)
+	err = tx.QueryRow("SELECT rule, state, starts_at, ends_at, description, owner, contact, tags FROM segments WHERE id = $1", source.id).
+		Scan(&rule, &newSegment.State, &newSegment.StartsAt, &newSegment.EndsAt, &newSegment.Description,
+			&newSegment.Owner, &newSegment.Contact, pq.Array(&newSegment.Tags))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if rule != nil {
+		newSegment.Rule = *rule
+	}
+	if newSegment.State == storage.SegmentStateArchived {
+		newSegment.State = storage.SegmentStateDraft
+	}
+
+	segment, err := s.insertSegment(tx, tenant, newSegment)
+	if err != nil {
+		return nil, err
+	}
+
+	// The sample is exact: the rounded share of the members, picked at random.
+	events, err := saveEvents(tx, `WITH members AS (
+			SELECT user_id FROM user_segments WHERE tenant = $1 AND segment_id = $2
+			ORDER BY random()
+			LIMIT (SELECT round(count(*) * $3::numeric / 100) FROM user_segments WHERE tenant = $1 AND segment_id = $2)
+		),
+		added AS (
+			INSERT INTO user_segments(tenant, user_id, segment_id)
+			SELECT $1, user_id, $4 FROM members
+			RETURNING user_id
+		)
+		INSERT INTO outbox(tenant, event_type, user_id, segment)
+		SELECT $1, $5, user_id, $6 FROM added`+returningEvent,
+		tenant, source.id, percent, segment.ID, storage.EventSegmentAdded, segment.Name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	s.publish(events)
+
+	return &storage.ClonedSegmentDTO{SegmentDTO: *segment, Source: source.name, Members: int64(len(events))}, nil
+}
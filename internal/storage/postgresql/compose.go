@@ -0,0 +1,212 @@
+package postgresql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/lib/pq"
+)
+
+type composedSegment struct {
+	id      int64
+	name    string
+	op      string
+	sources []int64
+}
+
+// composedMembers selects the users of a set operation over the sources in
+// $2, limited to the user in $4 unless it is zero.
+var composedMembers = map[string]string{
+	storage.SetOpUnion: `SELECT DISTINCT user_id FROM user_segments
+		WHERE tenant = $1 AND segment_id = ANY($2::int[]) AND ($4::bigint = 0 OR user_id = $4::bigint)`,
+	storage.SetOpIntersection: `SELECT user_id FROM user_segments
+		WHERE tenant = $1 AND segment_id = ANY($2::int[]) AND ($4::bigint = 0 OR user_id = $4::bigint)
+		GROUP BY user_id HAVING count(*) = cardinality($2::int[])`,
+	storage.SetOpDifference: `SELECT user_id FROM user_segments
+		WHERE tenant = $1 AND segment_id = ($2::int[])[1] AND ($4::bigint = 0 OR user_id = $4::bigint)
+		EXCEPT
+		SELECT user_id FROM user_segments
+		WHERE tenant = $1 AND segment_id = ANY(($2::int[])[2:])`,
+}
+
+// SaveComposedSegment creates a segment whose members are the result of a set
+// operation over existing segments and fills it with a single INSERT ... SELECT.
+func (s *Storage) SaveComposedSegment(tenant string, newSegment storage.NewSegmentDTO, composition storage.SegmentCompositionDTO) (*storage.ComposedSegmentDTO, error) {
+	const op = "storage.postgresql.SaveComposedSegment"
+
+	if _, ok := composedMembers[composition.Op]; !ok {
+		return nil, fmt.Errorf("%s: unknown set operation %q", op, composition.Op)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	sources := make([]int64, 0, len(composition.Sources))
+	seen := make(map[int64]struct{}, len(composition.Sources))
+	for _, name := range composition.Sources {
+		source, err := s.resolveSegment(tx, tenant, name)
+		if err != nil {
+			if errors.Is(err, storage.ErrSegmentNotFound) {
+				return nil, fmt.Errorf("%w: %s", storage.ErrSegmentNotFound, name)
+			}
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		// Members of rule segments are not stored, the composition would miss them.
+		if source.ruleBased {
+			return nil, fmt.Errorf("%w: %s", storage.ErrSegmentRuleBased, name)
+		}
+		// An alias and the name of one segment count once.
+		if _, ok := seen[source.id]; ok {
+			continue
+		}
+		seen[source.id] = struct{}{}
+		sources = append(sources, source.id)
+	}
+
+	segment, err := s.insertSegment(tx, tenant, newSegment)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("UPDATE segments SET composition_op = $2, composition_sources = $3, composition_live = $4 WHERE id = $1",
+		segment.ID, composition.Op, pq.Array(sources), composition.Live)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	events, err := refreshComposedSegment(tx, tenant, composedSegment{
+		id:      segment.ID,
+		name:    segment.Name,
+		op:      composition.Op,
+		sources: sources,
+	}, 0)
+	if err != nil {
+		if errors.Is(err, storage.ErrGroupConflict) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	s.publish(events)
+
+	return &storage.ComposedSegmentDTO{SegmentDTO: *segment, Members: int64(len(events))}, nil
+}
+
+// refreshComputedSegments recomputes the live compositions that depend on the
+// changed segments, for userId only unless it is zero. Compositions are
+// visited in creation order, their sources are always older, so a composition
+// of compositions sees its sources already up to date.
+func (s *Storage) refreshComputedSegments(tx *sql.Tx, tenant string, userId int64, changed []int64) ([]storage.EventDTO, error) {
+	const op = "storage.postgresql.refreshComputedSegments"
+
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	composed := make([]composedSegment, 0)
+	err := scanAll(tx, func(rows *sql.Rows) error {
+		var segment composedSegment
+		if err := rows.Scan(&segment.id, &segment.name, &segment.op, pq.Array(&segment.sources)); err != nil {
+			return err
+		}
+		composed = append(composed, segment)
+		return nil
+	}, "SELECT id, name, composition_op, composition_sources FROM segments WHERE tenant = $1 AND composition_live ORDER BY id", tenant)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	dirty := make(map[int64]struct{}, len(changed))
+	for _, id := range changed {
+		dirty[id] = struct{}{}
+	}
+
+	events := make([]storage.EventDTO, 0)
+	for _, segment := range composed {
+		affected := false
+		for _, source := range segment.sources {
+			if _, ok := dirty[source]; ok {
+				affected = true
+				break
+			}
+		}
+		if !affected {
+			continue
+		}
+
+		refreshed, err := refreshComposedSegment(tx, tenant, segment, userId)
+		if err != nil {
+			if errors.Is(err, storage.ErrGroupConflict) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if len(refreshed) > 0 {
+			dirty[segment.id] = struct{}{}
+		}
+		events = append(events, refreshed...)
+	}
+
+	return events, nil
+}
+
+// changedSegments returns the ids of the segments the events are about.
+func changedSegments(q querier, tenant string, events []storage.EventDTO) ([]int64, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(events))
+	for _, event := range events {
+		names = append(names, event.Segment)
+	}
+
+	ids := make([]int64, 0, len(names))
+	err := scanAll(q, func(rows *sql.Rows) error {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		return nil
+	}, "SELECT id FROM segments WHERE tenant = $1 AND name = ANY($2)", tenant, pq.Array(names))
+
+	return ids, err
+}
+
+// refreshComposedSegment brings the members of the segment in line with its
+// set operation, for userId only unless it is zero, and records the changes
+// in the outbox.
+func refreshComposedSegment(tx *sql.Tx, tenant string, segment composedSegment, userId int64) ([]storage.EventDTO, error) {
+	events, err := saveEvents(tx, `WITH expected AS (`+composedMembers[segment.op]+`),
+		removed AS (
+			DELETE FROM user_segments
+			WHERE tenant = $1 AND segment_id = $3 AND ($4::bigint = 0 OR user_id = $4::bigint)
+			  AND user_id NOT IN (SELECT user_id FROM expected)
+			RETURNING user_id, $5::text AS event_type
+		),
+		added AS (
+			INSERT INTO user_segments(tenant, user_id, segment_id)
+			SELECT $1, user_id, $3 FROM expected
+			ON CONFLICT (tenant, user_id, segment_id) DO NOTHING
+			RETURNING user_id, $6::text AS event_type
+		)
+		INSERT INTO outbox(tenant, event_type, user_id, segment)
+		SELECT $1, event_type, user_id, $7 FROM (SELECT * FROM removed UNION ALL SELECT * FROM added) AS changes`+returningEvent,
+		tenant, pq.Array(segment.sources), segment.id, userId, storage.EventSegmentRemoved, storage.EventSegmentAdded, segment.name)
+	if err != nil {
+		if isUniqueViolation(err, exclusiveGroupConstraint) {
+			return nil, storage.ErrGroupConflict
+		}
+		return nil, err
+	}
+
+	return events, nil
+}
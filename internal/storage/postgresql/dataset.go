@@ -0,0 +1,398 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/lib/pq"
+	"time"
+)
+
+// ExportDataset reads the whole dataset of the tenant from a single snapshot,
+// so the export is consistent even while memberships keep changing.
+func (s *Storage) ExportDataset(tenant string) (*storage.DatasetDTO, error) {
+	const op = "storage.postgresql.ExportDataset"
+
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	dataset := storage.DatasetDTO{
+		Groups:      make([]storage.DatasetGroupDTO, 0),
+		Segments:    make([]storage.DatasetSegmentDTO, 0),
+		Aliases:     make([]storage.DatasetAliasDTO, 0),
+		Users:       make([]storage.DatasetUserDTO, 0),
+		Memberships: make([]storage.DatasetMembershipDTO, 0),
+	}
+
+	err = scanAll(tx, func(rows *sql.Rows) error {
+		var group storage.DatasetGroupDTO
+		if err := rows.Scan(&group.Name, &group.Exclusive); err != nil {
+			return err
+		}
+		dataset.Groups = append(dataset.Groups, group)
+		return nil
+	}, "SELECT name, exclusive FROM segment_groups WHERE tenant = $1 ORDER BY name", tenant)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = scanAll(tx, func(rows *sql.Rows) error {
+		var (
+			segment     storage.DatasetSegmentDTO
+			composition storage.SegmentCompositionDTO
+		)
+		err := rows.Scan(&segment.Name, &segment.Rule, &segment.State, &segment.StartsAt, &segment.EndsAt, &segment.ArchivedAt,
+			&segment.Description, &segment.Owner, &segment.Contact, pq.Array(&segment.Tags), &segment.Group, &segment.CreatedAt, &segment.UpdatedAt,
+			&composition.Op, pq.Array(&composition.Sources), &composition.Live)
+		if err != nil {
+			return err
+		}
+		if composition.Op != "" {
+			segment.Composition = &composition
+		}
+		dataset.Segments = append(dataset.Segments, segment)
+		return nil
+	}, `SELECT segments.name, COALESCE(segments.rule, ''), segments.state, segments.starts_at, segments.ends_at, segments.archived_at,
+		segments.description, segments.owner, segments.contact, segments.tags, COALESCE(segment_groups.name, ''),
+		segments.created_at, segments.updated_at,
+		COALESCE(segments.composition_op, ''),
+		ARRAY(
+			SELECT sources.name FROM unnest(segments.composition_sources) WITH ORDINALITY AS s(id, ord)
+			JOIN segments AS sources ON sources.id = s.id ORDER BY s.ord
+		),
+		segments.composition_live
+		FROM segments LEFT JOIN segment_groups ON segment_groups.id = segments.group_id
+		WHERE segments.tenant = $1 ORDER BY segments.id`, tenant)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = scanAll(tx, func(rows *sql.Rows) error {
+		var alias storage.DatasetAliasDTO
+		if err := rows.Scan(&alias.Alias, &alias.Segment); err != nil {
+			return err
+		}
+		dataset.Aliases = append(dataset.Aliases, alias)
+		return nil
+	}, `SELECT segment_aliases.alias, segments.name
+		FROM segment_aliases JOIN segments ON segments.id = segment_aliases.segment_id
+		WHERE segment_aliases.tenant = $1 ORDER BY segment_aliases.alias`, tenant)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = scanAll(tx, func(rows *sql.Rows) error {
+		var (
+			user storage.DatasetUserDTO
+			raw  []byte
+		)
+		if err := rows.Scan(&user.ID, &raw); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(raw, &user.Attributes); err != nil {
+			return err
+		}
+		dataset.Users = append(dataset.Users, user)
+		return nil
+	}, "SELECT id, attributes FROM users WHERE tenant = $1 ORDER BY id", tenant)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = scanAll(tx, func(rows *sql.Rows) error {
+		var membership storage.DatasetMembershipDTO
+		if err := rows.Scan(&membership.UserID, &membership.Segment); err != nil {
+			return err
+		}
+		dataset.Memberships = append(dataset.Memberships, membership)
+		return nil
+	}, `SELECT user_segments.user_id, segments.name
+		FROM user_segments JOIN segments ON segments.id = user_segments.segment_id
+		WHERE user_segments.tenant = $1 ORDER BY user_segments.user_id, segments.name`, tenant)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &dataset, nil
+}
+
+// ImportDataset loads the dataset into the tenant in one transaction. Records
+// of the dataset overwrite existing ones with the same name or id. With
+// replace everything the dataset does not contain is removed first, so the
+// tenant ends up as a copy of the dataset. Membership events are written for
+// the memberships the import adds or removes, not for the ones it keeps.
+func (s *Storage) ImportDataset(tenant string, dataset storage.DatasetDTO, replace bool) error {
+	const op = "storage.postgresql.ImportDataset"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	quota := s.quota(tenant)
+	err = s.lockQuota(tx, tenant, "users", quota.MaxUsers)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	err = s.lockQuota(tx, tenant, "segments", quota.MaxSegments)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	groupNames := make([]string, 0, len(dataset.Groups))
+	groupExclusive := make([]bool, 0, len(dataset.Groups))
+	for _, group := range dataset.Groups {
+		groupNames = append(groupNames, group.Name)
+		groupExclusive = append(groupExclusive, group.Exclusive)
+	}
+	segmentNames := make([]string, 0, len(dataset.Segments))
+	for _, segment := range dataset.Segments {
+		segmentNames = append(segmentNames, segment.Name)
+	}
+	memberUsers := make([]int64, 0, len(dataset.Memberships))
+	memberSegments := make([]string, 0, len(dataset.Memberships))
+	for _, membership := range dataset.Memberships {
+		memberUsers = append(memberUsers, membership.UserID)
+		memberSegments = append(memberSegments, membership.Segment)
+	}
+	userIds := make([]int64, 0, len(dataset.Users))
+	userAttributes := make([]string, 0, len(dataset.Users))
+	var maxUserId int64
+	for _, user := range dataset.Users {
+		attrs := user.Attributes
+		if attrs == nil {
+			attrs = map[string]any{}
+		}
+		raw, err := json.Marshal(attrs)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		userIds = append(userIds, user.ID)
+		userAttributes = append(userAttributes, string(raw))
+		if user.ID > maxUserId {
+			maxUserId = user.ID
+		}
+	}
+
+	var events []storage.EventDTO
+	if replace {
+		// Memberships the dataset does not have are removed up front, the
+		// segments and users deleted below have none left to cascade.
+		events, err = saveEvents(tx, `WITH removed AS (
+				DELETE FROM user_segments USING segments
+				WHERE user_segments.tenant = $1 AND segments.id = user_segments.segment_id
+				  AND NOT EXISTS (
+					SELECT 1 FROM unnest($2::bigint[], $3::text[]) AS m(user_id, segment)
+					WHERE m.user_id = user_segments.user_id AND m.segment = segments.name
+				  )
+				RETURNING user_segments.user_id, segments.name
+			)
+			INSERT INTO outbox(tenant, event_type, user_id, segment)
+			SELECT $1, $4, removed.user_id, removed.name FROM removed`+returningEvent,
+			tenant, pq.Array(memberUsers), pq.Array(memberSegments), storage.EventSegmentRemoved)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		queries := []struct {
+			query string
+			args  []any
+		}{
+			{"DELETE FROM segment_aliases WHERE tenant = $1", []any{tenant}},
+			{"DELETE FROM segments WHERE tenant = $1 AND NOT name = ANY($2)", []any{tenant, pq.Array(segmentNames)}},
+			{"DELETE FROM segment_groups WHERE tenant = $1 AND NOT name = ANY($2)", []any{tenant, pq.Array(groupNames)}},
+			{"DELETE FROM users WHERE tenant = $1 AND NOT id = ANY($2)", []any{tenant, pq.Array(userIds)}},
+		}
+		for _, q := range queries {
+			if _, err := tx.Exec(q.query, q.args...); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO segment_groups(tenant, name, exclusive)
+		SELECT $1, g.name, g.exclusive FROM unnest($2::text[], $3::bool[]) AS g(name, exclusive)
+		ON CONFLICT (tenant, name) DO UPDATE SET exclusive = EXCLUDED.exclusive`,
+		tenant, pq.Array(groupNames), pq.Array(groupExclusive))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Sources of a composition precede it in the dataset, so they are
+	// already saved when its source names are turned into ids.
+	stmt, err := tx.Prepare(`INSERT INTO segments(tenant, name, rule, state, starts_at, ends_at, archived_at,
+		description, owner, contact, tags, group_id, created_at, updated_at,
+		composition_op, composition_sources, composition_live)
+		VALUES($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, $10, $11,
+			(SELECT id FROM segment_groups WHERE tenant = $1 AND name = $12), COALESCE($13, now()), COALESCE($14, now()),
+			NULLIF($15, ''),
+			CASE WHEN $15 <> '' THEN ARRAY(
+				SELECT sources.id FROM unnest($16::text[]) WITH ORDINALITY AS s(name, ord)
+				JOIN segments AS sources ON sources.tenant = $1 AND sources.name = s.name ORDER BY s.ord
+			) END,
+			$17)
+		ON CONFLICT (tenant, name) DO UPDATE SET
+			rule = EXCLUDED.rule, state = EXCLUDED.state,
+			starts_at = EXCLUDED.starts_at, ends_at = EXCLUDED.ends_at, archived_at = EXCLUDED.archived_at,
+			description = EXCLUDED.description, owner = EXCLUDED.owner, contact = EXCLUDED.contact, tags = EXCLUDED.tags,
+			group_id = EXCLUDED.group_id, created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at,
+			composition_op = EXCLUDED.composition_op, composition_sources = EXCLUDED.composition_sources,
+			composition_live = EXCLUDED.composition_live
+		RETURNING id`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	segmentIds := make([]int64, 0, len(dataset.Segments))
+	for _, segment := range dataset.Segments {
+		tags := segment.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		// Hand-written archives may leave the timestamps out.
+		var createdAt, updatedAt *time.Time
+		if !segment.CreatedAt.IsZero() {
+			createdAt = &segment.CreatedAt
+		}
+		if !segment.UpdatedAt.IsZero() {
+			updatedAt = &segment.UpdatedAt
+		}
+
+		composition := storage.SegmentCompositionDTO{Sources: []string{}}
+		if segment.Composition != nil {
+			composition = *segment.Composition
+		}
+
+		var id int64
+		err := stmt.QueryRow(tenant, segment.Name, segment.Rule, segment.State, segment.StartsAt, segment.EndsAt, segment.ArchivedAt,
+			segment.Description, segment.Owner, segment.Contact, pq.Array(tags), segment.Group, createdAt, updatedAt,
+			composition.Op, pq.Array(composition.Sources), composition.Live).Scan(&id)
+		if err != nil {
+			// Restoring an archived segment puts its memberships back into the group.
+			if isUniqueViolation(err, exclusiveGroupConstraint) {
+				return storage.ErrGroupConflict
+			}
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		segmentIds = append(segmentIds, id)
+	}
+
+	aliases := make([]string, 0, len(dataset.Aliases))
+	aliasSegments := make([]string, 0, len(dataset.Aliases))
+	for _, alias := range dataset.Aliases {
+		aliases = append(aliases, alias.Alias)
+		aliasSegments = append(aliasSegments, alias.Segment)
+	}
+	_, err = tx.Exec(`INSERT INTO segment_aliases(tenant, alias, segment_id)
+		SELECT $1, a.alias, segments.id
+		FROM unnest($2::text[], $3::text[]) AS a(alias, segment)
+		JOIN segments ON segments.tenant = $1 AND segments.name = a.segment
+		ON CONFLICT (tenant, alias) DO UPDATE SET segment_id = EXCLUDED.segment_id`,
+		tenant, pq.Array(aliases), pq.Array(aliasSegments))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Aliases share the namespace of segment names, in merge mode the dataset
+	// may clash with what the tenant already has.
+	var clash string
+	err = tx.QueryRow(`SELECT segment_aliases.alias FROM segment_aliases
+		JOIN segments ON segments.tenant = segment_aliases.tenant AND segments.name = segment_aliases.alias
+		WHERE segment_aliases.tenant = $1 LIMIT 1`, tenant).Scan(&clash)
+	if err == nil {
+		return fmt.Errorf("%w: %s is both a segment and an alias", storage.ErrSegmentExists, clash)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO users(tenant, id, attributes)
+		SELECT $1, u.id, u.attributes::jsonb FROM unnest($2::bigint[], $3::text[]) AS u(id, attributes)
+		ON CONFLICT (tenant, id) DO UPDATE SET attributes = EXCLUDED.attributes`,
+		tenant, pq.Array(userIds), pq.Array(userAttributes))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = advanceUserIds(tx, maxUserId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	added, err := saveEvents(tx, `WITH added AS (
+			INSERT INTO user_segments(tenant, user_id, segment_id)
+			SELECT $1, m.user_id, segments.id
+			FROM unnest($2::bigint[], $3::text[]) AS m(user_id, segment)
+			JOIN segments ON segments.tenant = $1 AND segments.name = m.segment
+			ON CONFLICT (tenant, user_id, segment_id) DO NOTHING
+			RETURNING user_id, segment_id
+		)
+		INSERT INTO outbox(tenant, event_type, user_id, segment)
+		SELECT $1, $4, added.user_id, segments.name FROM added JOIN segments ON segments.id = added.segment_id`+returningEvent,
+		tenant, pq.Array(memberUsers), pq.Array(memberSegments), storage.EventSegmentAdded)
+	events = append(events, added...)
+	if err == nil {
+		// Groups of existing memberships may have changed with the segments.
+		err = refreshExclusiveGroups(tx, segmentIds)
+	}
+	if err != nil {
+		if isUniqueViolation(err, exclusiveGroupConstraint) {
+			return storage.ErrGroupConflict
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Live compositions follow their sources, whatever members the dataset
+	// gave them.
+	computed, err := s.refreshComputedSegments(tx, tenant, 0, segmentIds)
+	if err != nil {
+		if errors.Is(err, storage.ErrGroupConflict) {
+			return err
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	events = append(events, computed...)
+
+	err = s.checkQuota(tx, tenant, "users", quota.MaxUsers)
+	if err != nil {
+		return err
+	}
+	err = s.checkQuota(tx, tenant, "segments", quota.MaxSegments)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.publish(events)
+
+	return nil
+}
+
+// scanAll runs the query and calls scan for every row.
+func scanAll(q querier, scan func(rows *sql.Rows) error, query string, args ...any) error {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,142 @@
+package postgresql
+
+import (
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/assign"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/lib/pq"
+)
+
+func (s *Storage) SaveExperiment(tenant string, experiment storage.ExperimentDTO) (*storage.ExperimentDTO, error) {
+	const op = "storage.postgresql.SaveExperiment"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	saved := storage.ExperimentDTO{Name: experiment.Name}
+	err = tx.QueryRow("INSERT INTO experiments(tenant, name) VALUES($1,$2) RETURNING id", tenant, experiment.Name).Scan(&saved.ID)
+	if err != nil {
+		if err, ok := err.(*pq.Error); ok && err.Code == uniqueViolation {
+			return nil, storage.ErrExperimentExists
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, variant := range experiment.Variants {
+		err := tx.QueryRow("INSERT INTO experiment_variants(experiment_id, name, weight) VALUES($1,$2,$3) RETURNING id",
+			saved.ID, variant.Name, variant.Weight).Scan(&variant.ID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		saved.Variants = append(saved.Variants, variant)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &saved, nil
+}
+
+func (s *Storage) DeleteExperiment(tenant string, name string) error {
+	const op = "storage.postgresql.DeleteExperiment"
+
+	res, err := s.db.Exec("DELETE FROM experiments WHERE tenant = $1 AND name = $2", tenant, name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if deleted == 0 {
+		return storage.ErrExperimentNotFound
+	}
+
+	return nil
+}
+
+// GetUserExperiments returns experiment -> variant for the user, assigning
+// a variant of every experiment the user is not in yet.
+func (s *Storage) GetUserExperiments(tenant string, userId int64) (map[string]string, error) {
+	const op = "storage.postgresql.GetUserExperiments"
+
+	rows, err := s.db.Query(`SELECT experiments.id, experiments.name,
+		       experiment_variants.id, experiment_variants.name, experiment_variants.weight,
+		       experiment_assignments.variant_id IS NOT NULL
+		FROM experiments
+		JOIN experiment_variants ON experiment_variants.experiment_id = experiments.id
+		LEFT JOIN experiment_assignments ON experiment_assignments.experiment_id = experiments.id
+		     AND experiment_assignments.tenant = $1
+		     AND experiment_assignments.user_id = $2
+		     AND experiment_assignments.variant_id = experiment_variants.id
+		WHERE experiments.tenant = $1 AND EXISTS (SELECT 1 FROM users WHERE tenant = $1 AND id = $2)
+		ORDER BY experiments.id, experiment_variants.id`, tenant, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	result := make(map[string]string)
+	experiments := make([]*storage.ExperimentDTO, 0)
+	var current *storage.ExperimentDTO
+	for rows.Next() {
+		var (
+			experiment storage.ExperimentDTO
+			variant    storage.VariantDTO
+			assigned   bool
+		)
+		err := rows.Scan(&experiment.ID, &experiment.Name, &variant.ID, &variant.Name, &variant.Weight, &assigned)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if current == nil || current.ID != experiment.ID {
+			current = &experiment
+			experiments = append(experiments, current)
+		}
+		current.Variants = append(current.Variants, variant)
+		if assigned {
+			result[current.Name] = variant.Name
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, experiment := range experiments {
+		if _, ok := result[experiment.Name]; ok {
+			continue
+		}
+
+		weights := make([]int, 0, len(experiment.Variants))
+		for _, variant := range experiment.Variants {
+			weights = append(weights, variant.Weight)
+		}
+		variant := experiment.Variants[assign.Variant(experiment.Name, userId, weights)]
+
+		_, err := s.db.Exec(`INSERT INTO experiment_assignments(tenant, user_id, experiment_id, variant_id) VALUES($1,$2,$3,$4)
+			ON CONFLICT (tenant, user_id, experiment_id) DO NOTHING`, tenant, userId, experiment.ID, variant.ID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		// A concurrent lookup may have assigned the user first, its choice wins.
+		var name string
+		err = s.db.QueryRow(`SELECT experiment_variants.name FROM experiment_assignments
+			JOIN experiment_variants ON experiment_variants.id = experiment_assignments.variant_id
+			WHERE experiment_assignments.tenant = $1 AND experiment_assignments.user_id = $2 AND experiment_assignments.experiment_id = $3`,
+			tenant, userId, experiment.ID).Scan(&name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		result[experiment.Name] = name
+	}
+
+	return result, nil
+}
@@ -0,0 +1,157 @@
+package postgresql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/lib/pq"
+)
+
+const (
+	uniqueViolation          = "23505"
+	exclusiveGroupConstraint = "user_segments_exclusive_group_key"
+)
+
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation && pqErr.Constraint == constraint
+}
+
+// SaveSegmentGroup creates the group or updates it by name, making segments
+// its only members. Memberships of the affected segments are re-checked, and
+// storage.ErrGroupConflict is returned if some user would be left with more
+// than one segment of an exclusive group.
+func (s *Storage) SaveSegmentGroup(tenant string, group storage.SegmentGroupDTO) (*storage.SegmentGroupDTO, error) {
+	const op = "storage.postgresql.SaveSegmentGroup"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var groupId int64
+	err = tx.QueryRow(`INSERT INTO segment_groups(tenant, name, exclusive) VALUES($1,$2,$3)
+		ON CONFLICT (tenant, name) DO UPDATE SET exclusive = EXCLUDED.exclusive
+		RETURNING id`, tenant, group.Name, group.Exclusive).Scan(&groupId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	segmentIds := make([]int64, 0, len(group.Segments))
+	for _, name := range group.Segments {
+		segment, err := s.resolveSegment(tx, tenant, name)
+		if err != nil {
+			if errors.Is(err, storage.ErrSegmentNotFound) {
+				return nil, fmt.Errorf("%w: %s", storage.ErrSegmentNotFound, name)
+			}
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		segmentIds = append(segmentIds, segment.id)
+	}
+
+	rows, err := tx.Query(`UPDATE segments SET group_id = CASE WHEN id = ANY($2) THEN $1::int END
+		WHERE group_id = $1 OR id = ANY($2)
+		RETURNING id`, groupId, pq.Array(segmentIds))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	affected := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		affected = append(affected, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = refreshExclusiveGroups(tx, affected)
+	if err != nil {
+		if isUniqueViolation(err, exclusiveGroupConstraint) {
+			return nil, storage.ErrGroupConflict
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &storage.SegmentGroupDTO{
+		ID:        groupId,
+		Name:      group.Name,
+		Exclusive: group.Exclusive,
+		Segments:  group.Segments,
+	}, nil
+}
+
+func (s *Storage) DeleteSegmentGroup(tenant string, name string) error {
+	const op = "storage.postgresql.DeleteSegmentGroup"
+
+	res, err := s.db.Exec("DELETE FROM segment_groups WHERE tenant = $1 AND name = $2", tenant, name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if deleted == 0 {
+		return storage.ErrGroupNotFound
+	}
+
+	return nil
+}
+
+// GetSegmentGroupSegments lists the names of the group's segments.
+func (s *Storage) GetSegmentGroupSegments(tenant string, name string) ([]string, error) {
+	const op = "storage.postgresql.GetSegmentGroupSegments"
+
+	var groupId int64
+	err := s.db.QueryRow("SELECT id FROM segment_groups WHERE tenant = $1 AND name = $2", tenant, name).Scan(&groupId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, storage.ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	segments := make([]string, 0)
+	err = scanAll(s.db, func(rows *sql.Rows) error {
+		var segment string
+		if err := rows.Scan(&segment); err != nil {
+			return err
+		}
+		segments = append(segments, segment)
+		return nil
+	}, "SELECT name FROM segments WHERE group_id = $1 ORDER BY name", groupId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return segments, nil
+}
+
+// refreshExclusiveGroups brings user_segments.exclusive_group_id of the
+// segments in line with their current group. Archived segments hold no group.
+func refreshExclusiveGroups(q querier, segmentIds []int64) error {
+	_, err := q.Exec(`UPDATE user_segments SET exclusive_group_id = current.group_id
+		FROM (
+			SELECT segments.id AS segment_id, segment_groups.id AS group_id
+			FROM segments
+			LEFT JOIN segment_groups ON segment_groups.id = segments.group_id AND segment_groups.exclusive
+				AND segments.state <> 'archived'
+			WHERE segments.id = ANY($1)
+		) AS current
+		WHERE user_segments.segment_id = current.segment_id
+		  AND user_segments.exclusive_group_id IS DISTINCT FROM current.group_id`, pq.Array(segmentIds))
+	return err
+}
@@ -0,0 +1,102 @@
+package postgresql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"time"
+)
+
+// ReserveIdempotencyKey claims the key for a new request. An expired key is
+// claimed again. If the key is still alive, storage.ErrIdempotencyKeyExists is
+// returned together with the stored record.
+func (s *Storage) ReserveIdempotencyKey(client, key, requestHash string, ttl time.Duration) (*storage.IdempotencyRecordDTO, error) {
+	const op = "storage.postgresql.ReserveIdempotencyKey"
+
+	stmt, err := s.db.Prepare(`INSERT INTO idempotency_keys(client, key, request_hash, expires_at)
+		VALUES($1, $2, $3, now() + $4::bigint * interval '1 millisecond')
+		ON CONFLICT (client, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status = NULL, response = NULL,
+		    created_at = now(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < now()
+		RETURNING request_hash`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var reserved string
+	err = stmt.QueryRow(client, key, requestHash, ttl.Milliseconds()).Scan(&reserved)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stmt, err = s.db.Prepare("SELECT request_hash, status, response FROM idempotency_keys WHERE client = $1 AND key = $2")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var (
+		record storage.IdempotencyRecordDTO
+		status sql.NullInt64
+	)
+	err = stmt.QueryRow(client, key).Scan(&record.RequestHash, &status, &record.Response)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	record.Completed = status.Valid
+	record.Status = int(status.Int64)
+
+	return &record, storage.ErrIdempotencyKeyExists
+}
+
+func (s *Storage) CompleteIdempotencyKey(client, key string, status int, response []byte) error {
+	const op = "storage.postgresql.CompleteIdempotencyKey"
+
+	stmt, err := s.db.Prepare("UPDATE idempotency_keys SET status = $3, response = $4 WHERE client = $1 AND key = $2")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.Exec(client, key, status, response)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ReleaseIdempotencyKey(client, key string) error {
+	const op = "storage.postgresql.ReleaseIdempotencyKey"
+
+	stmt, err := s.db.Prepare("DELETE FROM idempotency_keys WHERE client = $1 AND key = $2 AND status IS NULL")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.Exec(client, key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredIdempotencyKeys() (int64, error) {
+	const op = "storage.postgresql.DeleteExpiredIdempotencyKeys"
+
+	res, err := s.db.Exec("DELETE FROM idempotency_keys WHERE expires_at < now()")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deleted, nil
+}
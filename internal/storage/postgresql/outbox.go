@@ -0,0 +1,246 @@
+package postgresql
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"time"
+)
+
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryDead      = "dead"
+)
+
+const returningEvent = " RETURNING id, tenant, event_type, user_id, segment, created_at"
+
+func saveEvent(q querier, tenant string, eventType string, userId int64, segment string) (*storage.EventDTO, error) {
+	const op = "storage.postgresql.saveEvent"
+
+	var event storage.EventDTO
+	err := q.QueryRow("INSERT INTO outbox(tenant, event_type, user_id, segment) VALUES($1,$2,$3,$4)"+returningEvent, tenant, eventType, userId, segment).
+		Scan(&event.ID, &event.Tenant, &event.Type, &event.UserID, &event.Segment, &event.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &event, nil
+}
+
+// saveEvents runs an INSERT ... SELECT into the outbox that ends with returningEvent.
+func saveEvents(q querier, query string, args ...any) ([]storage.EventDTO, error) {
+	const op = "storage.postgresql.saveEvents"
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+func scanEvents(rows *sql.Rows) ([]storage.EventDTO, error) {
+	const op = "storage.postgresql.scanEvents"
+
+	events := make([]storage.EventDTO, 0)
+	for rows.Next() {
+		var event storage.EventDTO
+		err := rows.Scan(&event.ID, &event.Tenant, &event.Type, &event.UserID, &event.Segment, &event.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		events = append(events, event)
+	}
+	err := rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+func (s *Storage) publish(events []storage.EventDTO) {
+	if s.publisher == nil || len(events) == 0 {
+		return
+	}
+	s.publisher.Publish(events...)
+}
+
+// GetUserEvents returns the user's events with id greater than afterId in commit order.
+func (s *Storage) GetUserEvents(tenant string, userId, afterId int64, limit int) ([]storage.EventDTO, error) {
+	const op = "storage.postgresql.GetUserEvents"
+
+	rows, err := s.db.Query(`SELECT id, tenant, event_type, user_id, segment, created_at FROM outbox
+		WHERE tenant = $1 AND user_id = $2 AND id > $3 ORDER BY id LIMIT $4`, tenant, userId, afterId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+func (s *Storage) GetLastUserEventId(tenant string, userId int64) (int64, error) {
+	const op = "storage.postgresql.GetLastUserEventId"
+
+	var id int64
+	err := s.db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM outbox WHERE tenant = $1 AND user_id = $2", tenant, userId).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// FanOutEvents creates a pending delivery of every not yet dispatched outbox
+// event for each webhook registered in the event's tenant.
+func (s *Storage) FanOutEvents(limit int) (int64, error) {
+	const op = "storage.postgresql.FanOutEvents"
+
+	res, err := s.db.Exec(`WITH events AS (
+			UPDATE outbox SET dispatched_at = now()
+			WHERE id IN (
+				SELECT id FROM outbox WHERE dispatched_at IS NULL
+				ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, tenant
+		)
+		INSERT INTO webhook_deliveries(event_id, webhook_id)
+		SELECT events.id, webhooks.id FROM events JOIN webhooks ON webhooks.tenant = events.tenant
+		ON CONFLICT DO NOTHING`, limit)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	created, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return created, nil
+}
+
+// ClaimWebhookDeliveries returns due deliveries and postpones them by lease,
+// so a crashed dispatcher's deliveries are picked up again later.
+func (s *Storage) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]storage.WebhookDeliveryDTO, error) {
+	const op = "storage.postgresql.ClaimWebhookDeliveries"
+
+	rows, err := s.db.Query(`WITH due AS (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $1 AND next_attempt_at <= now()
+			ORDER BY next_attempt_at LIMIT $2 FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_deliveries SET next_attempt_at = now() + $3::bigint * interval '1 millisecond'
+		FROM due, webhooks, outbox
+		WHERE webhook_deliveries.id = due.id
+		  AND webhooks.id = webhook_deliveries.webhook_id
+		  AND outbox.id = webhook_deliveries.event_id
+		RETURNING webhook_deliveries.id, webhook_deliveries.attempts,
+		          webhooks.id, webhooks.url, webhooks.secret,
+		          outbox.id, outbox.tenant, outbox.event_type, outbox.user_id, outbox.segment, outbox.created_at`,
+		deliveryPending, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	deliveries := make([]storage.WebhookDeliveryDTO, 0)
+	for rows.Next() {
+		var d storage.WebhookDeliveryDTO
+		err := rows.Scan(&d.ID, &d.Attempts,
+			&d.Webhook.ID, &d.Webhook.URL, &d.Webhook.Secret,
+			&d.Event.ID, &d.Event.Tenant, &d.Event.Type, &d.Event.UserID, &d.Event.Segment, &d.Event.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deliveries, nil
+}
+
+func (s *Storage) MarkWebhookDelivered(deliveryId int64) error {
+	const op = "storage.postgresql.MarkWebhookDelivered"
+
+	_, err := s.db.Exec("UPDATE webhook_deliveries SET status = $2, attempts = attempts + 1, last_error = '', delivered_at = now() WHERE id = $1",
+		deliveryId, deliveryDelivered)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// MarkWebhookFailed schedules the next attempt after backoff, or moves the
+// delivery to the dead letters once maxAttempts is reached.
+func (s *Storage) MarkWebhookFailed(deliveryId int64, deliveryErr string, maxAttempts int, backoff time.Duration) error {
+	const op = "storage.postgresql.MarkWebhookFailed"
+
+	_, err := s.db.Exec(`UPDATE webhook_deliveries
+		SET attempts = attempts + 1, last_error = $2,
+		    status = CASE WHEN attempts + 1 >= $3 THEN $4 ELSE $5 END,
+		    next_attempt_at = now() + $6::bigint * interval '1 millisecond'
+		WHERE id = $1`,
+		deliveryId, deliveryErr, maxAttempts, deliveryDead, deliveryPending, backoff.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetWebhookDeadLetters(tenant string, limit, offset int) ([]storage.DeadLetterDTO, error) {
+	const op = "storage.postgresql.GetWebhookDeadLetters"
+
+	rows, err := s.db.Query(`SELECT id, webhook_id, url, attempts, last_error, event_id, tenant, event_type, user_id, segment, created_at
+		FROM webhook_dead_letters WHERE tenant = $1 ORDER BY id DESC LIMIT $2 OFFSET $3`, tenant, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	letters := make([]storage.DeadLetterDTO, 0)
+	for rows.Next() {
+		var l storage.DeadLetterDTO
+		err := rows.Scan(&l.ID, &l.WebhookID, &l.URL, &l.Attempts, &l.LastError,
+			&l.Event.ID, &l.Event.Tenant, &l.Event.Type, &l.Event.UserID, &l.Event.Segment, &l.Event.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		letters = append(letters, l)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return letters, nil
+}
+
+func (s *Storage) RequeueWebhookDelivery(tenant string, deliveryId int64) error {
+	const op = "storage.postgresql.RequeueWebhookDelivery"
+
+	res, err := s.db.Exec(`UPDATE webhook_deliveries SET status = $2, attempts = 0, next_attempt_at = now()
+		FROM webhooks WHERE webhooks.id = webhook_deliveries.webhook_id AND webhooks.tenant = $4
+		  AND webhook_deliveries.id = $1 AND webhook_deliveries.status = $3`,
+		deliveryId, deliveryPending, deliveryDead, tenant)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return storage.ErrDeliveryNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package postgresql
+
+import (
+	"errors"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/lib/pq"
+)
+
+// GetSegmentOverlap counts the common members of every pair of the segments
+// with a single self-join of user_segments. Rule segments have no stored
+// members and are rejected with storage.ErrSegmentRuleBased.
+func (s *Storage) GetSegmentOverlap(tenant string, names []string) (*storage.SegmentOverlapDTO, error) {
+	const op = "storage.postgresql.GetSegmentOverlap"
+
+	overlap := storage.SegmentOverlapDTO{
+		Segments:      make([]string, 0, len(names)),
+		Sizes:         make([]int64, len(names)),
+		Intersections: make([][]int64, len(names)),
+		Jaccard:       make([][]float64, len(names)),
+	}
+	ids := make([]int64, 0, len(names))
+	for _, name := range names {
+		segment, err := s.resolveSegment(s.db, tenant, name)
+		if err != nil {
+			if errors.Is(err, storage.ErrSegmentNotFound) {
+				return nil, fmt.Errorf("%w: %s", storage.ErrSegmentNotFound, name)
+			}
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if segment.ruleBased {
+			return nil, fmt.Errorf("%w: %s", storage.ErrSegmentRuleBased, name)
+		}
+		overlap.Segments = append(overlap.Segments, segment.name)
+		ids = append(ids, segment.id)
+	}
+
+	type pair struct{ a, b int64 }
+	common := make(map[pair]int64)
+
+	// a.segment_id <= b.segment_id yields the sizes on the diagonal and every
+	// other pair once.
+	rows, err := s.db.Query(`SELECT a.segment_id, b.segment_id, count(*)
+		FROM user_segments a
+		JOIN user_segments b ON b.tenant = a.tenant AND b.user_id = a.user_id AND b.segment_id >= a.segment_id
+		WHERE a.tenant = $1 AND a.segment_id = ANY($2) AND b.segment_id = ANY($2)
+		GROUP BY a.segment_id, b.segment_id`, tenant, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			p     pair
+			count int64
+		)
+		if err := rows.Scan(&p.a, &p.b, &count); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		common[p] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	intersection := func(a, b int64) int64 {
+		if a > b {
+			a, b = b, a
+		}
+		return common[pair{a, b}]
+	}
+
+	for i, id := range ids {
+		overlap.Sizes[i] = intersection(id, id)
+	}
+	for i := range ids {
+		overlap.Intersections[i] = make([]int64, len(ids))
+		overlap.Jaccard[i] = make([]float64, len(ids))
+		for j := range ids {
+			both := intersection(ids[i], ids[j])
+			overlap.Intersections[i][j] = both
+			if union := overlap.Sizes[i] + overlap.Sizes[j] - both; union > 0 {
+				overlap.Jaccard[i][j] = float64(both) / float64(union)
+			}
+		}
+	}
+
+	return &overlap, nil
+}
@@ -2,18 +2,43 @@ package postgresql
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/config"
 	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
 	"github.com/lib/pq"
 	_ "github.com/lib/pq"
+	"golang.org/x/exp/slog"
+	"sort"
 )
 
 type Storage struct {
-	db *sql.DB
+	log             *slog.Logger
+	db              *sql.DB
+	publisher       Publisher
+	autoCreateUsers bool
+	tenants         config.Tenants
 }
 
-func New(cfg config.Storage) (*Storage, error) {
+// Publisher is notified about membership events once their transaction is committed.
+type Publisher interface {
+	Publish(events ...storage.EventDTO)
+}
+
+// segmentVisible filters segments that are returned in user lookups: active
+// and inside their activation window. Memberships of other segments are kept.
+const segmentVisible = `segments.state = 'active'
+	AND (segments.starts_at IS NULL OR segments.starts_at <= now())
+	AND (segments.ends_at IS NULL OR segments.ends_at > now())`
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func New(log *slog.Logger, cfg config.Storage, tenants config.Tenants, publisher Publisher) (*Storage, error) {
 	const op = "storage.postgresql.New"
 
 	dataSource := fmt.Sprintf(
@@ -30,50 +55,232 @@ func New(cfg config.Storage) (*Storage, error) {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &Storage{db: db}, nil
+	return &Storage{
+		log:             log.With(slog.String("component", "storage")),
+		db:              db,
+		publisher:       publisher,
+		autoCreateUsers: cfg.AutoCreateUsers,
+		tenants:         tenants,
+	}, nil
 }
 
-func (s *Storage) SaveUser() (*storage.UserDTO, error) {
+func (s *Storage) SaveUser(tenant string) (*storage.UserDTO, error) {
 	const op = "storage.postgresql.SaveUser"
 
-	stmt, err := s.db.Prepare("INSERT INTO users(id) VALUES(DEFAULT) RETURNING *")
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	err = s.lockQuota(tx, tenant, "users", s.quota(tenant).MaxUsers)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
 	var user storage.UserDTO
-	err = stmt.QueryRow().Scan(&user.ID)
+	err = tx.QueryRow("INSERT INTO users(tenant) VALUES($1) RETURNING id", tenant).Scan(&user.ID)
 	if err != nil {
 		return nil, fmt.Errorf("%s: failed to get last insert id %w", op, err)
 	}
+
+	err = s.checkQuota(tx, tenant, "users", s.quota(tenant).MaxUsers)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
 	return &user, nil
 }
 
-func (s *Storage) DeleteUser(userId int64) error {
+// SaveUsers registers users under the given ids. Unless ignoreExisting is set,
+// an already registered id fails the whole batch with storage.ErrUserExists
+// and the returned DTO lists the existing ids.
+func (s *Storage) SaveUsers(tenant string, ids []int64, ignoreExisting bool) (*storage.SavedUsersDTO, error) {
+	const op = "storage.postgresql.SaveUsers"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	created, err := s.insertUsers(tx, tenant, ids)
+	if err != nil {
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	result := storage.SavedUsersDTO{
+		Created:  created,
+		Existing: make([]int64, 0),
+	}
+	isCreated := make(map[int64]struct{}, len(created))
+	for _, id := range created {
+		isCreated[id] = struct{}{}
+	}
+	for _, id := range ids {
+		if _, ok := isCreated[id]; !ok {
+			result.Existing = append(result.Existing, id)
+		}
+	}
+
+	if len(result.Existing) > 0 && !ignoreExisting {
+		return &storage.SavedUsersDTO{Created: make([]int64, 0), Existing: result.Existing}, storage.ErrUserExists
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &result, nil
+}
+
+// insertUsers inserts the users that do not exist yet and returns their ids.
+func (s *Storage) insertUsers(tx *sql.Tx, tenant string, ids []int64) ([]int64, error) {
+	const op = "storage.postgresql.insertUsers"
+
+	err := s.lockQuota(tx, tenant, "users", s.quota(tenant).MaxUsers)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := tx.Query("INSERT INTO users(tenant, id) SELECT DISTINCT $1, unnest($2::bigint[]) ON CONFLICT DO NOTHING RETURNING id", tenant, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var maxId int64
+	created := make([]int64, 0, len(ids))
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		created = append(created, id)
+		if id > maxId {
+			maxId = id
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = s.checkQuota(tx, tenant, "users", s.quota(tenant).MaxUsers)
+	if err != nil {
+		return nil, err
+	}
+
+	err = advanceUserIds(tx, maxId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return created, nil
+}
+
+// advanceUserIds moves the user id sequence past maxId, so generated ids
+// continue after the largest explicit one and never collide.
+func advanceUserIds(tx *sql.Tx, maxId int64) error {
+	if maxId <= 0 {
+		return nil
+	}
+	_, err := tx.Exec(`SELECT setval(pg_get_serial_sequence('users', 'id'), $1)
+		WHERE $1 > COALESCE(pg_sequence_last_value(pg_get_serial_sequence('users', 'id')::regclass), 0)`, maxId)
+	return err
+}
+
+func (s *Storage) DeleteUser(tenant string, userId int64) error {
 	const op = "storage.postgresql.DeleteUser"
 
-	stmt, err := s.db.Prepare("DELETE FROM users WHERE id = $1")
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	events, err := saveEvents(tx, `INSERT INTO outbox(tenant, event_type, user_id, segment)
+		SELECT user_segments.tenant, $1, user_segments.user_id, segments.name
+		FROM user_segments JOIN segments ON user_segments.segment_id = segments.id
+		WHERE user_segments.tenant = $2 AND user_segments.user_id = $3`+returningEvent, storage.EventSegmentRemoved, tenant, userId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.Exec("DELETE FROM users WHERE tenant = $1 AND id = $2", tenant, userId)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	_, err = stmt.Exec(userId)
-	//if err != nil {
-	//	return fmt.Errorf("%s: %w", op, err)
-	//}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	s.publish(events)
+
 	return nil
 }
 
-func (s *Storage) SaveSegment(name string) (*storage.SegmentDTO, error) {
+func (s *Storage) SaveSegment(tenant string, newSegment storage.NewSegmentDTO) (*storage.SegmentDTO, error) {
 	const op = "storage.postgresql.SaveSegment"
 
-	stmt, err := s.db.Prepare("INSERT INTO segments(name) VALUES($1) RETURNING *")
+	tx, err := s.db.Begin()
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	defer tx.Rollback()
+
+	segment, err := s.insertSegment(tx, tenant, newSegment)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return segment, nil
+}
+
+// insertSegment creates the segment within the tenant's segment quota.
+func (s *Storage) insertSegment(tx *sql.Tx, tenant string, newSegment storage.NewSegmentDTO) (*storage.SegmentDTO, error) {
+	const op = "storage.postgresql.insertSegment"
+
+	err := s.lockQuota(tx, tenant, "segments", s.quota(tenant).MaxSegments)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Aliases share the namespace of segment names.
+	var isAlias bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM segment_aliases WHERE tenant = $1 AND alias = $2)", tenant, newSegment.Name).Scan(&isAlias)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if isAlias {
+		return nil, storage.ErrSegmentExists
+	}
+
+	tags := newSegment.Tags
+	if tags == nil {
+		tags = []string{}
+	}
 
 	var segment storage.SegmentDTO
-	err = stmt.QueryRow(name).Scan(&segment.ID, &segment.Name)
+	err = tx.QueryRow(`INSERT INTO segments(tenant, name, rule, state, starts_at, ends_at, description, owner, contact, tags)
+		VALUES($1, $2, NULLIF($3, ''), COALESCE(NULLIF($4, ''), 'active'), $5, $6, $7, $8, $9, $10) RETURNING id, name`,
+		tenant, newSegment.Name, newSegment.Rule, newSegment.State, newSegment.StartsAt, newSegment.EndsAt,
+		newSegment.Description, newSegment.Owner, newSegment.Contact, pq.Array(tags)).Scan(&segment.ID, &segment.Name)
 	if err != nil {
 		if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
 			return nil, storage.ErrSegmentExists
@@ -81,103 +288,442 @@ func (s *Storage) SaveSegment(name string) (*storage.SegmentDTO, error) {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
+	err = s.checkQuota(tx, tenant, "segments", s.quota(tenant).MaxSegments)
+	if err != nil {
+		return nil, err
+	}
+
 	return &segment, nil
 }
 
-func (s *Storage) DeleteSegment(name string) error {
+// DeleteSegment archives the segment. It disappears from user lookups, but
+// memberships and history are kept and it can be restored.
+func (s *Storage) DeleteSegment(tenant string, name string) error {
 	const op = "storage.postgresql.DeleteSegment"
 
-	stmt, err := s.db.Prepare("DELETE FROM segments WHERE name = '?'")
+	res, err := s.db.Exec("UPDATE segments SET state = 'archived', archived_at = now(), updated_at = now() WHERE tenant = $1 AND name = $2 AND state <> 'archived'", tenant, name)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
-
-	_, err = stmt.Exec(name)
-
-	//if err != nil {
-	//	return fmt.Errorf("%s: %w", op, err)
-	//}
+	archived, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if archived == 0 {
+		return storage.ErrSegmentNotFound
+	}
 
 	return nil
 }
 
-func (s *Storage) AddUserToSegments(segmentsToSave []string, segmentsToDelete []string, userId int64) (*storage.UserInSegmentDTO, error) {
-	const op = "storage.postgresql.AddUserToSegments"
+func (s *Storage) RestoreSegment(tenant string, name string) error {
+	const op = "storage.postgresql.RestoreSegment"
 
-	addSegments := make([]string, 0)
-	notAddSegments := make([]string, 0)
-	for _, segment := range segmentsToSave {
-		err := s.AddUserSegment(segment, userId)
-		if err != nil {
-			notAddSegments = append(notAddSegments, segment)
-		} else {
-			addSegments = append(addSegments, segment)
+	res, err := s.db.Exec("UPDATE segments SET state = 'active', archived_at = NULL, updated_at = now() WHERE tenant = $1 AND name = $2 AND state = 'archived'", tenant, name)
+	if err != nil {
+		// Memberships rejoin the exclusive group, see segments_refresh_exclusive_group.
+		if isUniqueViolation(err, exclusiveGroupConstraint) {
+			return storage.ErrGroupConflict
 		}
+		return fmt.Errorf("%s: %w", op, err)
 	}
-	for _, segment := range segmentsToDelete {
-		s.DeleteUserSegment(segment, userId)
+	restored, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if restored == 0 {
+		return segmentStateError(s.db, tenant, name, storage.ErrSegmentNotArchived)
 	}
-	var userInSegment storage.UserInSegmentDTO
-	userInSegment.UserID = userId
-	userInSegment.AddedSegments = addSegments
-	userInSegment.NotAddedSegments = notAddSegments
-	userInSegment.DeletedSegments = segmentsToDelete
 
-	return &userInSegment, nil
+	return nil
 }
 
-func (s *Storage) AddUserSegment(name string, id int64) error {
-	const op = "storage.postgresql.AddUserSegment"
+// SetSegmentState moves a segment between draft, active and paused.
+// Archiving goes through DeleteSegment and RestoreSegment.
+func (s *Storage) SetSegmentState(tenant string, name string, state string) error {
+	const op = "storage.postgresql.SetSegmentState"
 
-	segmentId, err := s.GetSegmentId(name)
+	res, err := s.db.Exec("UPDATE segments SET state = $3, updated_at = now() WHERE tenant = $1 AND name = $2 AND state <> 'archived'", tenant, name, state)
 	if err != nil {
-		return storage.ErrSegmentNotFound
+		return fmt.Errorf("%s: %w", op, err)
 	}
-	err = s.GetUserId(id)
+	updated, err := res.RowsAffected()
 	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if updated == 0 {
+		return segmentStateError(s.db, tenant, name, storage.ErrSegmentArchived)
+	}
+
+	return nil
+}
+
+// segmentStateError tells a missing segment apart from one in the wrong state.
+func segmentStateError(q querier, tenant string, name string, stateErr error) error {
+	var exists bool
+	err := q.QueryRow("SELECT EXISTS(SELECT 1 FROM segments WHERE tenant = $1 AND name = $2)", tenant, name).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
 		return storage.ErrSegmentNotFound
 	}
-	stmt, err := s.db.Prepare("INSERT INTO user_segments(user_id, segment_id) VALUES($1,$2)")
+	return stateErr
+}
+
+// PurgeSegment removes an archived segment together with its memberships.
+func (s *Storage) PurgeSegment(tenant string, name string) error {
+	const op = "storage.postgresql.PurgeSegment"
+
+	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
-	_, err = stmt.Exec(id, segmentId)
+	defer tx.Rollback()
+
+	events, err := saveEvents(tx, `INSERT INTO outbox(tenant, event_type, user_id, segment)
+		SELECT segments.tenant, $1, user_segments.user_id, segments.name
+		FROM user_segments JOIN segments ON user_segments.segment_id = segments.id
+		WHERE segments.tenant = $2 AND segments.name = $3 AND segments.state = 'archived'`+returningEvent, storage.EventSegmentRemoved, tenant, name)
 	if err != nil {
-		if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
-			return storage.ErrUserAlreadyInSegment
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	var purgedId int64
+	err = tx.QueryRow("DELETE FROM segments WHERE tenant = $1 AND name = $2 AND state = 'archived' RETURNING id", tenant, name).Scan(&purgedId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return segmentStateError(tx, tenant, name, storage.ErrSegmentNotArchived)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Compositions of the purged segment lose its members.
+	var changed []int64
+	if len(events) > 0 {
+		changed = []int64{purgedId}
+	}
+	computed, err := s.refreshComputedSegments(tx, tenant, 0, changed)
+	if err != nil {
+		if errors.Is(err, storage.ErrGroupConflict) {
+			return err
 		}
 		return fmt.Errorf("%s: %w", op, err)
 	}
+	events = append(events, computed...)
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	s.publish(events)
 
 	return nil
 }
 
-func (s *Storage) GetSegmentId(name string) (int64, error) {
-	const op = "storage.postgresql.GetSegmentId"
+func (s *Storage) AddUserToSegments(tenant string, segmentsToSave []string, segmentsToDelete []string, userId int64, opts storage.MembershipOptionsDTO) (*storage.UserInSegmentDTO, error) {
+	const op = "storage.postgresql.AddUserToSegments"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
 
-	stmt, err := s.db.Prepare("SELECT id FROM segments WHERE name = '?'")
+	err = s.lockUser(tx, tenant, userId, !opts.DryRun)
 	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
+		if errors.Is(err, storage.ErrUserNotFound) || errors.Is(err, storage.ErrQuotaExceeded) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	var segmentId int64
-	err = stmt.QueryRow(name).Scan(&segmentId)
+	version, err := checkUserVersion(tx, tenant, userId, opts.IfVersion)
 	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return segmentId, nil
+	userInSegment, events, err := s.changeUserSegments(tx, tenant, userId, segmentsToSave, segmentsToDelete, opts)
+	if err != nil {
+		if errors.Is(err, storage.ErrSegmentConflict) || errors.Is(err, storage.ErrGroupConflict) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// A dry run takes the same path and leaves the rollback to the deferred
+	// call, so nothing is written or published.
+	if opts.DryRun {
+		userInSegment.Version = version
+		return userInSegment, nil
+	}
+
+	userInSegment.Version, err = userVersion(tx, tenant, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	s.publish(events)
+
+	return userInSegment, nil
 }
 
-func (s *Storage) GetUserId(id int64) error {
-	const op = "storage.postgresql.GetUserId"
+// SetUserSegments makes segments the only explicit memberships of the user,
+// computing the diff with the current ones in the same transaction. Only
+// memberships in namespaces are removed, nil means any namespace.
+// Memberships of archived segments are kept for a restore and live
+// compositions follow their sources, so neither is touched.
+func (s *Storage) SetUserSegments(tenant string, userId int64, segments []string, namespaces []string, ifVersion *int64) (*storage.UserSegmentsDiffDTO, error) {
+	const op = "storage.postgresql.SetUserSegments"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	err = s.lockUser(tx, tenant, userId, true)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) || errors.Is(err, storage.ErrQuotaExceeded) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	version, err := checkUserVersion(tx, tenant, userId, ifVersion)
+	if err != nil {
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	desired := make(map[string]struct{}, len(segments))
+	for _, name := range segments {
+		segment, err := s.resolveSegment(tx, tenant, name)
+		if err != nil {
+			if errors.Is(err, storage.ErrSegmentNotFound) {
+				return nil, fmt.Errorf("%w: %s", storage.ErrSegmentNotFound, name)
+			}
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if segment.state == storage.SegmentStateArchived {
+			return nil, fmt.Errorf("%w: %s", storage.ErrSegmentArchived, name)
+		}
+		if segment.computed {
+			return nil, fmt.Errorf("%w: %s", storage.ErrSegmentComputed, name)
+		}
+		desired[segment.name] = struct{}{}
+	}
+
+	current := make(map[string]struct{})
+	err = scanAll(tx, func(rows *sql.Rows) error {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		current[name] = struct{}{}
+		return nil
+	}, `SELECT segments.name FROM user_segments JOIN segments ON segments.id = user_segments.segment_id
+		WHERE user_segments.tenant = $1 AND user_segments.user_id = $2
+		  AND segments.state <> 'archived' AND NOT segments.composition_live
+		  AND ($3::text[] IS NULL OR segments.namespace = ANY($3))`, tenant, userId, pq.Array(namespaces))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Names are canonical here, aliases were resolved above.
+	segmentsToSave := make([]string, 0)
+	for name := range desired {
+		if _, ok := current[name]; !ok {
+			segmentsToSave = append(segmentsToSave, name)
+		}
+	}
+	segmentsToDelete := make([]string, 0)
+	for name := range current {
+		if _, ok := desired[name]; !ok {
+			segmentsToDelete = append(segmentsToDelete, name)
+		}
+	}
+	sort.Strings(segmentsToSave)
+	sort.Strings(segmentsToDelete)
+
+	res, events, err := s.changeUserSegments(tx, tenant, userId, segmentsToSave, segmentsToDelete, storage.MembershipOptionsDTO{})
+	if err != nil {
+		if errors.Is(err, storage.ErrSegmentConflict) || errors.Is(err, storage.ErrGroupConflict) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	version, err = userVersion(tx, tenant, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	s.publish(events)
+
+	// A segment of an exclusive group that is swapped for a desired one is
+	// removed by the add and is a no-op for the delete.
+	removed := make([]string, 0, len(segmentsToDelete))
+	notDeleted := make(map[string]struct{}, len(res.NotDeletedSegments))
+	for _, name := range res.NotDeletedSegments {
+		notDeleted[name] = struct{}{}
+	}
+	for _, name := range segmentsToDelete {
+		if _, ok := notDeleted[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	removed = append(removed, res.ReplacedSegments...)
+	sort.Strings(removed)
+
+	return &storage.UserSegmentsDiffDTO{UserID: userId, Version: version, Added: res.AddedSegments, Removed: removed}, nil
+}
+
+// changeUserSegments applies a membership change of a locked user and
+// refreshes the live compositions it affects. The caller owns the
+// transaction and publishes the returned events after the commit.
+func (s *Storage) changeUserSegments(tx *sql.Tx, tenant string, userId int64, segmentsToSave []string, segmentsToDelete []string, opts storage.MembershipOptionsDTO) (*storage.UserInSegmentDTO, []storage.EventDTO, error) {
+	const op = "storage.postgresql.changeUserSegments"
+
+	// A segment that is going to be deleted anyway never blocks a new one.
+	toDelete := make(map[string]struct{}, len(segmentsToDelete))
+	for _, segment := range segmentsToDelete {
+		toDelete[segment] = struct{}{}
+	}
+	swap := func(competitor string) bool {
+		_, ok := toDelete[competitor]
+		return ok || opts.SwapExclusive
+	}
+
+	events := make([]storage.EventDTO, 0)
+	userInSegment := storage.UserInSegmentDTO{
+		UserID:             userId,
+		AddedSegments:      make([]string, 0),
+		NotAddedSegments:   make([]string, 0),
+		AlreadyInSegments:  make([]string, 0),
+		MissingSegments:    make([]string, 0),
+		DeletedSegments:    segmentsToDelete,
+		NotDeletedSegments: make([]string, 0),
+		ReplacedSegments:   make([]string, 0),
+	}
+	for _, segment := range segmentsToSave {
+		added, replaced, err := s.addUserSegment(tx, tenant, segment, userId, swap)
+		switch {
+		case err == nil:
+			if replaced != nil {
+				events = append(events, *replaced)
+				userInSegment.ReplacedSegments = append(userInSegment.ReplacedSegments, replaced.Segment)
+			}
+			events = append(events, *added)
+			userInSegment.AddedSegments = append(userInSegment.AddedSegments, segment)
+		case errors.Is(err, storage.ErrSegmentNotFound), errors.Is(err, storage.ErrSegmentArchived), errors.Is(err, storage.ErrUserAlreadyInSegment),
+			errors.Is(err, storage.ErrSegmentComputed):
+			userInSegment.NotAddedSegments = append(userInSegment.NotAddedSegments, segment)
+			if errors.Is(err, storage.ErrSegmentNotFound) {
+				userInSegment.MissingSegments = append(userInSegment.MissingSegments, segment)
+			}
+			if errors.Is(err, storage.ErrUserAlreadyInSegment) {
+				userInSegment.AlreadyInSegments = append(userInSegment.AlreadyInSegments, segment)
+			}
+		case errors.Is(err, storage.ErrSegmentConflict):
+			return nil, nil, err
+		default:
+			return nil, nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	for _, segment := range segmentsToDelete {
+		event, err := s.deleteUserSegment(tx, tenant, segment, userId)
+		switch {
+		case err == nil:
+			events = append(events, *event)
+		case errors.Is(err, storage.ErrSegmentNotFound), errors.Is(err, storage.ErrUserSegmentNotFound), errors.Is(err, storage.ErrSegmentComputed):
+			userInSegment.NotDeletedSegments = append(userInSegment.NotDeletedSegments, segment)
+		default:
+			return nil, nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	changed, err := changedSegments(tx, tenant, events)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+	computed, err := s.refreshComputedSegments(tx, tenant, userId, changed)
+	if err != nil {
+		if errors.Is(err, storage.ErrGroupConflict) {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+	events = append(events, computed...)
+
+	return &userInSegment, events, nil
+}
 
-	stmt, err := s.db.Prepare("SELECT id FROM users WHERE id = $1")
+// checkUserVersion returns the version of the memberships of the locked user
+// and fails with storage.ErrVersionMismatch unless it is expected, nil
+// expects any version.
+func checkUserVersion(tx *sql.Tx, tenant string, userId int64, expected *int64) (int64, error) {
+	version, err := userVersion(tx, tenant, userId)
 	if err != nil {
+		return 0, err
+	}
+	if expected != nil && *expected != version {
+		return 0, storage.ErrVersionMismatch
+	}
+
+	return version, nil
+}
+
+// userVersion returns the version of the user's memberships, zero for an
+// unknown user.
+func userVersion(q querier, tenant string, userId int64) (int64, error) {
+	var version int64
+	err := q.QueryRow("SELECT COALESCE((SELECT version FROM users WHERE tenant = $1 AND id = $2), 0)", tenant, userId).Scan(&version)
+
+	return version, err
+}
+
+// lockUser locks the user's row for the rest of the transaction, so that
+// concurrent membership changes of one user are applied one after another.
+// An unknown user is registered when both autoCreateUsers and create are set.
+// A dry run passes create=false: registering moves the user id sequence, and
+// sequences are not rolled back with the transaction.
+func (s *Storage) lockUser(tx *sql.Tx, tenant string, userId int64, create bool) error {
+	const op = "storage.postgresql.lockUser"
+
+	var id int64
+	err := tx.QueryRow("SELECT id FROM users WHERE tenant = $1 AND id = $2 FOR UPDATE", tenant, userId).Scan(&id)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
 		return fmt.Errorf("%s: %w", op, err)
 	}
-	var userId int64
-	err = stmt.QueryRow(id).Scan(&userId)
+	if !s.autoCreateUsers || !create {
+		return storage.ErrUserNotFound
+	}
+
+	_, err = s.insertUsers(tx, tenant, []int64{userId})
+	if err != nil {
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			return err
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	err = tx.QueryRow("SELECT id FROM users WHERE tenant = $1 AND id = $2 FOR UPDATE", tenant, userId).Scan(&id)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
@@ -185,42 +731,164 @@ func (s *Storage) GetUserId(id int64) error {
 	return nil
 }
 
-func (s *Storage) DeleteUserSegment(name string, id int64) {
-	const op = "storage.postgresql.DeleteUserSegment"
+// addUserSegment adds the user to the segment. If the user already has another
+// segment of the same exclusive group, that segment is removed when swap
+// allows it, otherwise a *storage.SegmentConflictError is returned.
+func (s *Storage) addUserSegment(tx *sql.Tx, tenant string, name string, id int64, swap func(competitor string) bool) (*storage.EventDTO, *storage.EventDTO, error) {
+	const op = "storage.postgresql.addUserSegment"
+
+	segment, err := s.resolveSegment(tx, tenant, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if segment.state == storage.SegmentStateArchived {
+		return nil, nil, storage.ErrSegmentArchived
+	}
+	if segment.computed {
+		return nil, nil, storage.ErrSegmentComputed
+	}
+
+	var replaced *storage.EventDTO
+	competitor, group, err := exclusiveCompetitor(tx, tenant, id, segment.id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if competitor != "" {
+		if !swap(competitor) {
+			return nil, nil, &storage.SegmentConflictError{Segment: name, ConflictsWith: competitor, Group: group}
+		}
+		replaced, err = s.deleteUserSegment(tx, tenant, competitor, id)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	// ON CONFLICT keeps the transaction usable when the user is already in the segment.
+	res, err := tx.Exec("INSERT INTO user_segments(tenant, user_id, segment_id) VALUES($1,$2,$3) ON CONFLICT (tenant, user_id, segment_id) DO NOTHING", tenant, id, segment.id)
+	if err != nil {
+		if isUniqueViolation(err, exclusiveGroupConstraint) {
+			return nil, nil, storage.ErrSegmentConflict
+		}
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if inserted == 0 {
+		return nil, nil, storage.ErrUserAlreadyInSegment
+	}
+
+	added, err := saveEvent(tx, tenant, storage.EventSegmentAdded, id, segment.name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return added, replaced, nil
+}
+
+// exclusiveCompetitor returns the user's segment that shares an exclusive
+// group with segmentId, if there is one.
+func exclusiveCompetitor(q querier, tenant string, userId, segmentId int64) (string, string, error) {
+	var competitor, group string
+	err := q.QueryRow(`SELECT segments.name, segment_groups.name
+		FROM user_segments
+		JOIN segments ON segments.id = user_segments.segment_id
+		JOIN segment_groups ON segment_groups.id = user_segments.exclusive_group_id
+		WHERE user_segments.tenant = $1 AND user_segments.user_id = $2 AND user_segments.segment_id <> $3
+		  AND user_segments.exclusive_group_id = (
+			SELECT segment_groups.id FROM segments
+			JOIN segment_groups ON segment_groups.id = segments.group_id AND segment_groups.exclusive
+			WHERE segments.id = $3
+		  )`, tenant, userId, segmentId).Scan(&competitor, &group)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	return competitor, group, nil
+}
 
-	segmentId, err := s.GetSegmentId(name)
+func (s *Storage) deleteUserSegment(tx *sql.Tx, tenant string, name string, id int64) (*storage.EventDTO, error) {
+	const op = "storage.postgresql.deleteUserSegment"
+
+	segment, err := s.resolveSegment(tx, tenant, name)
 	if err != nil {
-		return
+		return nil, err
+	}
+	if segment.computed {
+		return nil, storage.ErrSegmentComputed
 	}
-	err = s.GetUserId(id)
+
+	res, err := tx.Exec("DELETE FROM user_segments WHERE tenant = $1 AND user_id = $2 AND segment_id = $3", tenant, id, segment.id)
 	if err != nil {
-		return
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if deleted == 0 {
+		return nil, storage.ErrUserSegmentNotFound
+	}
+
+	return saveEvent(tx, tenant, storage.EventSegmentRemoved, id, segment.name)
+}
 
-	stmt, err := s.db.Prepare("DELETE FROM user_segments WHERE user_id = $1 AND segment_id = $2")
+func (s *Storage) GetSegmentId(tenant string, name string) (int64, error) {
+	segment, err := s.resolveSegment(s.db, tenant, name)
 	if err != nil {
-		return
+		return 0, err
 	}
 
-	_, _ = stmt.Exec(id, segmentId)
+	return segment.id, nil
+}
 
-	return
+func (s *Storage) GetUserId(tenant string, id int64) error {
+	return getUserId(s.db, tenant, id)
 }
 
-func (s *Storage) GetUserSegments(userId int64) (*storage.UserSegmentsDTO, error) {
+func getUserId(q querier, tenant string, id int64) error {
+	const op = "storage.postgresql.GetUserId"
+
+	var userId int64
+	err := q.QueryRow("SELECT id FROM users WHERE tenant = $1 AND id = $2", tenant, id).Scan(&userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrUserNotFound
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetUserSegments(tenant string, userId int64) (*storage.UserSegmentsDTO, error) {
 	const op = "storage.postgresql.GetUserSegments"
 
-	stmt, err := s.db.Prepare("SELECT segment_id, segments.name FROM user_segments JOIN segments ON user_segments.segment_id = segments.id WHERE user_id = $1")
+	// The version is read before the segments: a change in between makes it
+	// stale, which fails a later If-Match instead of hiding the change.
+	version, err := userVersion(s.db, tenant, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stmt, err := s.db.Prepare("SELECT segment_id, segments.name FROM user_segments JOIN segments ON user_segments.segment_id = segments.id WHERE user_segments.tenant = $1 AND user_id = $2 AND " + segmentVisible)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	rows, err := stmt.Query(userId)
+	rows, err := stmt.Query(tenant, userId)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	defer rows.Close()
+
 	var userSegments storage.UserSegmentsDTO
 	userSegments.UserId = userId
+	userSegments.Version = version
 	for rows.Next() {
 		var segment storage.SegmentDTO
 		err := rows.Scan(&segment.ID, &segment.Name)
@@ -234,5 +902,16 @@ func (s *Storage) GetUserSegments(userId int64) (*storage.UserSegmentsDTO, error
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
+	ruleSegments, err := s.matchRuleSegments(tenant, userId, userSegments.Segments)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	userSegments.Segments = append(userSegments.Segments, ruleSegments...)
+
+	userSegments.Experiments, err = s.GetUserExperiments(tenant, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
 	return &userSegments, nil
 }
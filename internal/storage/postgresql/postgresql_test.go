@@ -0,0 +1,62 @@
+package postgresql
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"golang.org/x/exp/slog"
+	"io"
+	"os"
+	"testing"
+)
+
+// newTestStorage connects to the database in POSTGRES_TEST_DSN, which must
+// have the schema of assets/postgres/init.sql. Tests are skipped without it.
+func newTestStorage(t *testing.T, autoCreateUsers bool) *Storage {
+	t.Helper()
+
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Ping(); err != nil {
+		t.Fatalf("ping database: %v", err)
+	}
+
+	return &Storage{
+		log:             slog.New(slog.NewTextHandler(io.Discard, nil)),
+		db:              db,
+		autoCreateUsers: autoCreateUsers,
+	}
+}
+
+func TestDryRunKeepsUserIds(t *testing.T) {
+	s := newTestStorage(t, true)
+
+	const tenant = "test-dry-run"
+	t.Cleanup(func() { s.db.Exec("DELETE FROM users WHERE tenant = $1", tenant) })
+
+	first, err := s.SaveUser(tenant)
+	if err != nil {
+		t.Fatalf("SaveUser() error = %v", err)
+	}
+
+	_, err = s.AddUserToSegments(tenant, nil, nil, first.ID+1000, storage.MembershipOptionsDTO{DryRun: true})
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		t.Fatalf("AddUserToSegments() error = %v, want %v", err, storage.ErrUserNotFound)
+	}
+
+	next, err := s.SaveUser(tenant)
+	if err != nil {
+		t.Fatalf("SaveUser() error = %v", err)
+	}
+	if next.ID != first.ID+1 {
+		t.Errorf("SaveUser() id = %d after a dry run, want %d", next.ID, first.ID+1)
+	}
+}
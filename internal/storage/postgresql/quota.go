@@ -0,0 +1,45 @@
+package postgresql
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/config"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+)
+
+func (s *Storage) quota(tenant string) config.Quota {
+	return s.tenants.Quotas[tenant]
+}
+
+// lockQuota serializes inserts into the tenant's table until the end of the
+// transaction, so that concurrent requests cannot both pass checkQuota.
+// Tenants without a limit are not locked.
+func (s *Storage) lockQuota(tx *sql.Tx, tenant string, table string, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1 || '/' || $2))", table, tenant)
+	return err
+}
+
+// checkQuota returns storage.ErrQuotaExceeded when the tenant has more rows
+// in table than limit. It is called after the insert, under lockQuota.
+func (s *Storage) checkQuota(tx *sql.Tx, tenant string, table string, limit int) error {
+	const op = "storage.postgresql.checkQuota"
+
+	if limit <= 0 {
+		return nil
+	}
+
+	var count int
+	err := tx.QueryRow("SELECT count(*) FROM "+table+" WHERE tenant = $1", tenant).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if count > limit {
+		return fmt.Errorf("%w: %s limit of %d reached", storage.ErrQuotaExceeded, table, limit)
+	}
+
+	return nil
+}
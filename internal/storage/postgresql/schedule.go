@@ -0,0 +1,32 @@
+package postgresql
+
+import (
+	"fmt"
+)
+
+// ArchiveEndedSegments archives the segments of all tenants whose activation
+// window is over and returns them as "tenant:name".
+func (s *Storage) ArchiveEndedSegments() ([]string, error) {
+	const op = "storage.postgresql.ArchiveEndedSegments"
+
+	rows, err := s.db.Query("UPDATE segments SET state = 'archived', archived_at = now(), updated_at = now() WHERE ends_at <= now() AND state <> 'archived' RETURNING tenant || ':' || name")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	archived := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		archived = append(archived, name)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return archived, nil
+}
@@ -0,0 +1,122 @@
+package postgresql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/lib/pq"
+	"strings"
+)
+
+const defaultSegmentsLimit = 100
+
+const segmentInfoColumns = `id, name, namespace, COALESCE(rule, ''), state, starts_at, ends_at, archived_at,
+	description, owner, contact, tags, created_at, updated_at`
+
+func scanSegmentInfo(row interface{ Scan(dest ...any) error }) (*storage.SegmentInfoDTO, error) {
+	var segment storage.SegmentInfoDTO
+	err := row.Scan(&segment.ID, &segment.Name, &segment.Namespace, &segment.Rule, &segment.State, &segment.StartsAt, &segment.EndsAt, &segment.ArchivedAt,
+		&segment.Description, &segment.Owner, &segment.Contact, pq.Array(&segment.Tags), &segment.CreatedAt, &segment.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if segment.Tags == nil {
+		segment.Tags = []string{}
+	}
+
+	return &segment, nil
+}
+
+func (s *Storage) UpdateSegment(tenant string, name string, patch storage.SegmentPatchDTO) (*storage.SegmentInfoDTO, error) {
+	const op = "storage.postgresql.UpdateSegment"
+
+	var tags any
+	if patch.Tags != nil {
+		tags = pq.Array(*patch.Tags)
+	}
+
+	row := s.db.QueryRow(`UPDATE segments SET
+		description = COALESCE($3, description),
+		owner = COALESCE($4, owner),
+		contact = COALESCE($5, contact),
+		tags = COALESCE($6::text[], tags),
+		updated_at = now()
+		WHERE tenant = $1 AND name = $2 RETURNING `+segmentInfoColumns,
+		tenant, name, patch.Description, patch.Owner, patch.Contact, tags)
+	segment, err := scanSegmentInfo(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrSegmentNotFound
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return segment, nil
+}
+
+func (s *Storage) GetSegments(tenant string, filter storage.SegmentFilterDTO) ([]storage.SegmentInfoDTO, error) {
+	const op = "storage.postgresql.GetSegments"
+
+	var (
+		conds []string
+		args  []any
+	)
+	addCond := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	addCond("tenant = $%d", tenant)
+	if filter.Namespace != "" {
+		addCond("namespace = $%d", filter.Namespace)
+	}
+	if filter.Namespaces != nil {
+		addCond("namespace = ANY($%d)", pq.Array(filter.Namespaces))
+	}
+	if filter.Owner != "" {
+		addCond("owner = $%d", filter.Owner)
+	}
+	if len(filter.Tags) > 0 {
+		addCond("tags @> $%d::text[]", pq.Array(filter.Tags))
+	}
+	if filter.State != "" {
+		addCond("state = $%d", filter.State)
+	}
+	if filter.Query != "" {
+		addCond("(name ILIKE '%%' || $%[1]d || '%%' OR description ILIKE '%%' || $%[1]d || '%%')", filter.Query)
+	}
+
+	query := "SELECT " + segmentInfoColumns + " FROM segments"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultSegmentsLimit
+	}
+	args = append(args, limit, filter.Offset)
+	query += fmt.Sprintf(" ORDER BY name LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	segments := make([]storage.SegmentInfoDTO, 0)
+	for rows.Next() {
+		segment, err := scanSegmentInfo(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		segments = append(segments, *segment)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return segments, nil
+}
@@ -0,0 +1,138 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/lib/pq"
+	"strings"
+)
+
+// GetSegmentStats counts members of the segments and their daily adds and
+// removes. The outbox is the membership history: events recorded under a
+// former name of a renamed segment are counted for the segment. Rule
+// segments have no stored members: they are left out of the list, and naming
+// one fails with storage.ErrSegmentRuleBased.
+func (s *Storage) GetSegmentStats(tenant string, filter storage.SegmentStatsFilterDTO) (*storage.SegmentStatsDTO, error) {
+	const op = "storage.postgresql.GetSegmentStats"
+
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	names := make([]string, 0, len(filter.Segments))
+	for _, name := range filter.Segments {
+		segment, err := s.resolveSegment(tx, tenant, name)
+		if err != nil {
+			if errors.Is(err, storage.ErrSegmentNotFound) {
+				return nil, fmt.Errorf("%w: %s", storage.ErrSegmentNotFound, name)
+			}
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if segment.ruleBased {
+			return nil, fmt.Errorf("%w: %s", storage.ErrSegmentRuleBased, name)
+		}
+		names = append(names, segment.name)
+	}
+
+	stats := storage.SegmentStatsDTO{Segments: make([]storage.SegmentStatDTO, 0)}
+	summary := &stats.Summary
+	err = tx.QueryRow(`SELECT
+		(SELECT count(*) FROM users WHERE tenant = $1),
+		(SELECT count(*) FROM segments WHERE tenant = $1),
+		(SELECT count(*) FROM user_segments WHERE tenant = $1),
+		(SELECT count(DISTINCT user_id) FROM user_segments WHERE tenant = $1)`, tenant).
+		Scan(&summary.Users, &summary.Segments, &summary.Memberships, &summary.UsersInSegments)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if summary.Users > 0 {
+		summary.AvgSegmentsPerUser = float64(summary.Memberships) / float64(summary.Users)
+	}
+
+	var (
+		conds []string
+		args  []any
+	)
+	addCond := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	addCond("segments.tenant = $%d", tenant)
+	conds = append(conds, "segments.rule IS NULL")
+	if len(names) > 0 {
+		addCond("segments.name = ANY($%d)", pq.Array(names))
+	}
+	if filter.Namespaces != nil {
+		addCond("segments.namespace = ANY($%d)", pq.Array(filter.Namespaces))
+	}
+
+	rows, err := tx.Query(`SELECT segments.name, segments.state, count(user_segments.id)
+		FROM segments LEFT JOIN user_segments ON user_segments.segment_id = segments.id
+		WHERE `+strings.Join(conds, " AND ")+`
+		GROUP BY segments.id ORDER BY segments.name`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	index := make(map[string]int)
+	selected := make([]string, 0)
+	for rows.Next() {
+		stat := storage.SegmentStatDTO{Daily: make([]storage.DailyStatDTO, 0)}
+		if err := rows.Scan(&stat.Name, &stat.State, &stat.Members); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if summary.Users > 0 {
+			stat.Share = float64(stat.Members) / float64(summary.Users)
+		}
+		index[stat.Name] = len(stats.Segments)
+		selected = append(selected, stat.Name)
+		stats.Segments = append(stats.Segments, stat)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err = tx.Query(`WITH names AS (
+			SELECT name AS segment, name AS recorded FROM segments WHERE tenant = $1 AND name = ANY($2)
+			UNION ALL
+			SELECT segments.name, segment_aliases.alias
+			FROM segment_aliases JOIN segments ON segments.id = segment_aliases.segment_id
+			WHERE segments.tenant = $1 AND segments.name = ANY($2)
+		)
+		SELECT names.segment, to_char((outbox.created_at AT TIME ZONE 'UTC')::date, 'YYYY-MM-DD') AS day,
+			count(*) FILTER (WHERE outbox.event_type = $5),
+			count(*) FILTER (WHERE outbox.event_type = $6)
+		FROM outbox JOIN names ON names.recorded = outbox.segment
+		WHERE outbox.tenant = $1 AND outbox.created_at >= $3 AND outbox.created_at < $4
+		GROUP BY names.segment, day
+		ORDER BY names.segment, day`,
+		tenant, pq.Array(selected), filter.From, filter.To, storage.EventSegmentAdded, storage.EventSegmentRemoved)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			name string
+			day  storage.DailyStatDTO
+		)
+		if err := rows.Scan(&name, &day.Date, &day.Added, &day.Removed); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		stat := &stats.Segments[index[name]]
+		stat.Daily = append(stat.Daily, day)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &stats, nil
+}
@@ -0,0 +1,51 @@
+package postgresql
+
+import (
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"github.com/lib/pq"
+)
+
+func (s *Storage) SaveWebhook(tenant, url, secret string) (*storage.WebhookDTO, error) {
+	const op = "storage.postgresql.SaveWebhook"
+
+	stmt, err := s.db.Prepare("INSERT INTO webhooks(tenant, url, secret) VALUES($1,$2,$3) RETURNING id, url, created_at")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var webhook storage.WebhookDTO
+	err = stmt.QueryRow(tenant, url, secret).Scan(&webhook.ID, &webhook.URL, &webhook.CreatedAt)
+	if err != nil {
+		if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+			return nil, storage.ErrWebhookExists
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &webhook, nil
+}
+
+func (s *Storage) DeleteWebhook(tenant string, id int64) error {
+	const op = "storage.postgresql.DeleteWebhook"
+
+	stmt, err := s.db.Prepare("DELETE FROM webhooks WHERE tenant = $1 AND id = $2")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.Exec(tenant, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if deleted == 0 {
+		return storage.ErrWebhookNotFound
+	}
+
+	return nil
+}
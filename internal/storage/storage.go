@@ -1,6 +1,31 @@
 package storage
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	EventSegmentAdded   = "segment.added"
+	EventSegmentRemoved = "segment.removed"
+)
+
+// Set operations a segment can be composed of. Difference takes the members
+// of the first source that are in none of the others.
+const (
+	SetOpUnion        = "union"
+	SetOpIntersection = "intersection"
+	SetOpDifference   = "difference"
+)
+
+const (
+	SegmentStateDraft    = "draft"
+	SegmentStateActive   = "active"
+	SegmentStatePaused   = "paused"
+	SegmentStateArchived = "archived"
+)
 
 var (
 	ErrSegmentNotFound      = errors.New("Segment not found")
@@ -8,25 +33,368 @@ var (
 	ErrUserAlreadyInSegment = errors.New("User already in segment")
 	ErrUserSegmentNotFound  = errors.New("User in segment not found")
 	ErrUserNotFound         = errors.New("User not found")
+	ErrUserExists           = errors.New("User exists")
+	ErrIdempotencyKeyExists = errors.New("Idempotency key exists")
+	ErrWebhookExists        = errors.New("Webhook exists")
+	ErrWebhookNotFound      = errors.New("Webhook not found")
+	ErrDeliveryNotFound     = errors.New("Webhook delivery not found")
+	ErrSegmentConflict      = errors.New("Segment conflicts with another segment of an exclusive group")
+	ErrGroupConflict        = errors.New("Users already have several segments of the group")
+	ErrGroupNotFound        = errors.New("Segment group not found")
+	ErrExperimentExists     = errors.New("Experiment exists")
+	ErrExperimentNotFound   = errors.New("Experiment not found")
+	ErrSegmentArchived      = errors.New("Segment archived")
+	ErrSegmentNotArchived   = errors.New("Segment not archived")
+	ErrAliasNotFound        = errors.New("Segment alias not found")
+	ErrQuotaExceeded        = errors.New("Tenant quota exceeded")
+	ErrSegmentComputed      = errors.New("Segment members are computed from other segments")
+	ErrSegmentRuleBased     = errors.New("Segment members are matched by a rule")
+	ErrVersionMismatch      = errors.New("User segments changed")
 )
 
 type UserDTO struct {
 	ID int64
 }
 
+type SavedUsersDTO struct {
+	Created  []int64
+	Existing []int64
+}
+
+type UserAttributesDTO struct {
+	UserID     int64
+	Attributes map[string]any
+}
+
 type SegmentDTO struct {
 	ID   int64
 	Name string
 }
 
+type NewSegmentDTO struct {
+	Name        string
+	Rule        string
+	State       string
+	StartsAt    *time.Time
+	EndsAt      *time.Time
+	Description string
+	Owner       string
+	Contact     string
+	Tags        []string
+}
+
+// SegmentCompositionDTO defines a segment as a set operation over Sources.
+// A Live segment is recomputed whenever memberships of its sources change.
+type SegmentCompositionDTO struct {
+	Op      string
+	Sources []string
+	Live    bool
+}
+
+type ComposedSegmentDTO struct {
+	SegmentDTO
+	Members int64
+}
+
+type ClonedSegmentDTO struct {
+	SegmentDTO
+	Source  string
+	Members int64
+}
+
+type SegmentInfoDTO struct {
+	ID          int64
+	Name        string
+	Namespace   string
+	Rule        string
+	State       string
+	StartsAt    *time.Time
+	EndsAt      *time.Time
+	ArchivedAt  *time.Time
+	Description string
+	Owner       string
+	Contact     string
+	Tags        []string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// SegmentPatchDTO holds metadata changes, nil fields are left as is.
+type SegmentPatchDTO struct {
+	Description *string
+	Owner       *string
+	Contact     *string
+	Tags        *[]string
+}
+
+type SegmentAliasDTO struct {
+	Alias      string
+	Segment    string
+	UsageCount int64
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// SegmentFilterDTO narrows segment listing. Namespaces restricts it to the
+// namespaces granted to the caller, nil means no restriction.
+type SegmentFilterDTO struct {
+	Namespace  string
+	Namespaces []string
+	Owner      string
+	Tags       []string
+	State      string
+	Query      string
+	Limit      int
+	Offset     int
+}
+
+type RuleSegmentDTO struct {
+	SegmentDTO
+	Rule string
+}
+
+// SegmentConflictError is returned when a membership would break the
+// exclusivity of a segment group.
+type SegmentConflictError struct {
+	Segment       string
+	ConflictsWith string
+	Group         string
+}
+
+func (e *SegmentConflictError) Error() string {
+	return fmt.Sprintf("segment %s conflicts with %s in exclusive group %s", e.Segment, e.ConflictsWith, e.Group)
+}
+
+func (e *SegmentConflictError) Unwrap() error {
+	return ErrSegmentConflict
+}
+
+type SegmentGroupDTO struct {
+	ID        int64
+	Name      string
+	Exclusive bool
+	Segments  []string
+}
+
+type MembershipOptionsDTO struct {
+	// SwapExclusive replaces the user's segment of an exclusive group instead
+	// of failing with a *SegmentConflictError.
+	SwapExclusive bool
+	// DryRun computes the result and rolls the transaction back.
+	DryRun bool
+	// IfVersion fails the change with ErrVersionMismatch unless the user's
+	// memberships are at this version.
+	IfVersion *int64
+}
+
+// UserInSegmentDTO is the diff of a membership change. NotAddedSegments
+// includes AlreadyInSegments and MissingSegments, the segments the user was
+// already in and the ones that do not exist. DeletedSegments echoes the
+// request, NotDeletedSegments are the removals that changed nothing.
+// Version is the version of the memberships after the change, before it
+// for a dry run.
 type UserInSegmentDTO struct {
-	UserID           int64
-	AddedSegments    []string
-	NotAddedSegments []string
-	DeletedSegments  []string
+	UserID             int64
+	Version            int64
+	AddedSegments      []string
+	NotAddedSegments   []string
+	AlreadyInSegments  []string
+	MissingSegments    []string
+	DeletedSegments    []string
+	NotDeletedSegments []string
+	ReplacedSegments   []string
+}
+
+type UserSegmentsDiffDTO struct {
+	UserID  int64
+	Version int64
+	Added   []string
+	Removed []string
 }
 
+// UserSegmentsDTO lists the segments of a user. Version covers the explicit
+// memberships, rule segments and experiments do not change it.
 type UserSegmentsDTO struct {
-	UserId   int64
-	Segments []SegmentDTO
+	UserId      int64
+	Version     int64
+	Segments    []SegmentDTO
+	Experiments map[string]string
+}
+
+type VariantDTO struct {
+	ID     int64
+	Name   string
+	Weight int
+}
+
+type ExperimentDTO struct {
+	ID       int64
+	Name     string
+	Variants []VariantDTO
+}
+
+type AuditEntryDTO struct {
+	ID        int64
+	Tenant    string
+	Actor     string
+	RequestID string
+	IP        string
+	Action    string
+	Payload   json.RawMessage
+	Result    string
+	Error     string
+	CreatedAt time.Time
+}
+
+type AuditFilterDTO struct {
+	Actor     string
+	Action    string
+	RequestID string
+	Result    string
+	From      *time.Time
+	To        *time.Time
+	Limit     int
+	Offset    int
+}
+
+type IdempotencyRecordDTO struct {
+	RequestHash string
+	Completed   bool
+	Status      int
+	Response    []byte
+}
+
+type EventDTO struct {
+	ID        int64
+	Tenant    string
+	Type      string
+	UserID    int64
+	Segment   string
+	CreatedAt time.Time
+}
+
+type WebhookDTO struct {
+	ID        int64
+	URL       string
+	Secret    string
+	CreatedAt time.Time
+}
+
+type WebhookDeliveryDTO struct {
+	ID       int64
+	Attempts int
+	Webhook  WebhookDTO
+	Event    EventDTO
+}
+
+type DeadLetterDTO struct {
+	ID        int64
+	WebhookID int64
+	URL       string
+	Attempts  int
+	LastError string
+	Event     EventDTO
+}
+
+// DatasetDTO holds everything of a tenant that is moved between environments:
+// groups, segments with their metadata and aliases, users and memberships.
+type DatasetDTO struct {
+	Groups      []DatasetGroupDTO
+	Segments    []DatasetSegmentDTO
+	Aliases     []DatasetAliasDTO
+	Users       []DatasetUserDTO
+	Memberships []DatasetMembershipDTO
+}
+
+type DatasetGroupDTO struct {
+	Name      string
+	Exclusive bool
+}
+
+type DatasetSegmentDTO struct {
+	Name        string
+	Rule        string
+	State       string
+	StartsAt    *time.Time
+	EndsAt      *time.Time
+	ArchivedAt  *time.Time
+	Description string
+	Owner       string
+	Contact     string
+	Tags        []string
+	Group       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	// Composition is set for segments built by a set operation, sources
+	// are referred to by name.
+	Composition *SegmentCompositionDTO
+}
+
+type DatasetAliasDTO struct {
+	Alias   string
+	Segment string
+}
+
+type DatasetUserDTO struct {
+	ID         int64
+	Attributes map[string]any
+}
+
+type DatasetMembershipDTO struct {
+	UserID  int64
+	Segment string
+}
+
+// SegmentStatsFilterDTO selects the segments to report on, all of them when
+// Segments is empty. Daily history covers [From, To).
+type SegmentStatsFilterDTO struct {
+	Segments   []string
+	Namespaces []string
+	From       time.Time
+	To         time.Time
+}
+
+type SegmentStatsDTO struct {
+	Summary  StatsSummaryDTO
+	Segments []SegmentStatDTO
+}
+
+type StatsSummaryDTO struct {
+	Users              int64
+	Segments           int64
+	Memberships        int64
+	UsersInSegments    int64
+	AvgSegmentsPerUser float64
+}
+
+type SegmentStatDTO struct {
+	Name    string
+	State   string
+	Members int64
+	// Share is the part of all users of the tenant that are members.
+	Share float64
+	Daily []DailyStatDTO
+}
+
+type DailyStatDTO struct {
+	Date    string
+	Added   int64
+	Removed int64
+}
+
+// SegmentOverlapDTO is a symmetric matrix over Segments: Intersections[i][j]
+// users are in both segments i and j, Jaccard[i][j] is that count divided by
+// the users in either of them. The diagonal holds the segment sizes.
+type SegmentOverlapDTO struct {
+	Segments      []string
+	Sizes         []int64
+	Intersections [][]int64
+	Jaccard       [][]float64
+}
+
+// AudienceDTO holds the number of users matching an audience query and a
+// page of their ids in ascending order.
+type AudienceDTO struct {
+	Count int64
+	Users []int64
 }
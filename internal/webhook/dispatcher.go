@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/config"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/lib/logger/sl"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const maxErrorLength = 1024
+
+type Store interface {
+	FanOutEvents(limit int) (int64, error)
+	ClaimWebhookDeliveries(limit int, lease time.Duration) ([]storage.WebhookDeliveryDTO, error)
+	MarkWebhookDelivered(deliveryId int64) error
+	MarkWebhookFailed(deliveryId int64, deliveryErr string, maxAttempts int, backoff time.Duration) error
+}
+
+type Payload struct {
+	ID        int64     `json:"id"`
+	Tenant    string    `json:"tenant"`
+	Type      string    `json:"type"`
+	UserID    int64     `json:"user_id"`
+	Segment   string    `json:"segment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Dispatcher struct {
+	log    *slog.Logger
+	cfg    config.Webhooks
+	store  Store
+	client *http.Client
+}
+
+func NewDispatcher(log *slog.Logger, cfg config.Webhooks, store Store) *Dispatcher {
+	return &Dispatcher{
+		log:    log.With(slog.String("component", "webhook/dispatcher")),
+		cfg:    cfg,
+		store:  store,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.log.Info("webhook dispatcher started", slog.String("interval", d.cfg.Interval.String()))
+
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			d.log.Info("webhook dispatcher stopped")
+			return
+		case <-ticker.C:
+			d.dispatch(ctx)
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context) {
+	if _, err := d.store.FanOutEvents(d.cfg.BatchSize); err != nil {
+		d.log.Error("failed to fan out outbox events", sl.Err(err))
+	}
+
+	// The lease outlives the slowest possible attempt, so a claimed delivery
+	// is not handed out twice while it is in flight.
+	deliveries, err := d.store.ClaimWebhookDeliveries(d.cfg.BatchSize, 2*d.cfg.Timeout)
+	if err != nil {
+		d.log.Error("failed to claim webhook deliveries", sl.Err(err))
+		return
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, d.cfg.Workers)
+	for _, delivery := range deliveries {
+		delivery := delivery
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			d.deliver(ctx, delivery)
+		}()
+	}
+	wg.Wait()
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery storage.WebhookDeliveryDTO) {
+	log := d.log.With(
+		slog.Int64("delivery_id", delivery.ID),
+		slog.Int64("event_id", delivery.Event.ID),
+		slog.String("url", delivery.Webhook.URL),
+	)
+
+	err := d.send(ctx, delivery)
+	if err == nil {
+		if err := d.store.MarkWebhookDelivered(delivery.ID); err != nil {
+			log.Error("failed to mark webhook delivered", sl.Err(err))
+		}
+		return
+	}
+
+	attempt := delivery.Attempts + 1
+	log.Warn("webhook delivery failed", sl.Err(err), slog.Int("attempt", attempt))
+
+	msg := err.Error()
+	if len(msg) > maxErrorLength {
+		msg = msg[:maxErrorLength]
+	}
+	if err := d.store.MarkWebhookFailed(delivery.ID, msg, d.cfg.MaxAttempts, d.backoff(attempt)); err != nil {
+		log.Error("failed to mark webhook failed", sl.Err(err))
+	}
+	if attempt >= d.cfg.MaxAttempts {
+		log.Error("webhook delivery moved to dead letters")
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery storage.WebhookDeliveryDTO) error {
+	body, err := json.Marshal(Payload{
+		ID:        delivery.Event.ID,
+		Tenant:    delivery.Event.Tenant,
+		Type:      delivery.Event.Type,
+		UserID:    delivery.Event.UserID,
+		Segment:   delivery.Event.Segment,
+		CreatedAt: delivery.Event.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEventID, strconv.FormatInt(delivery.Event.ID, 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Webhook.Secret, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, maxErrorLength))
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// backoff doubles the delay with every attempt up to BackoffMax.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.cfg.BackoffBase
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= d.cfg.BackoffMax {
+			return d.cfg.BackoffMax
+		}
+	}
+	return delay
+}
@@ -0,0 +1,189 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/config"
+	"github.com/DanilaNik/avito-backend-trainee-assignment-2023/internal/storage"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type failure struct {
+	deliveryId  int64
+	err         string
+	maxAttempts int
+	backoff     time.Duration
+}
+
+type fakeStore struct {
+	mu        sync.Mutex
+	delivered []int64
+	failed    []failure
+}
+
+func (s *fakeStore) FanOutEvents(int) (int64, error) { return 0, nil }
+
+func (s *fakeStore) ClaimWebhookDeliveries(int, time.Duration) ([]storage.WebhookDeliveryDTO, error) {
+	return nil, nil
+}
+
+func (s *fakeStore) MarkWebhookDelivered(deliveryId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delivered = append(s.delivered, deliveryId)
+	return nil
+}
+
+func (s *fakeStore) MarkWebhookFailed(deliveryId int64, deliveryErr string, maxAttempts int, backoff time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = append(s.failed, failure{deliveryId: deliveryId, err: deliveryErr, maxAttempts: maxAttempts, backoff: backoff})
+	return nil
+}
+
+func newTestDispatcher(store Store) *Dispatcher {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return NewDispatcher(log, config.Webhooks{
+		Timeout:     time.Second,
+		MaxAttempts: 3,
+		BackoffBase: time.Second,
+		BackoffMax:  10 * time.Second,
+	}, store)
+}
+
+func testDelivery(url string, attempts int) storage.WebhookDeliveryDTO {
+	return storage.WebhookDeliveryDTO{
+		ID:       7,
+		Attempts: attempts,
+		Webhook:  storage.WebhookDTO{ID: 1, URL: url, Secret: "secret"},
+		Event: storage.EventDTO{
+			ID:        42,
+			Tenant:    "default",
+			Type:      storage.EventSegmentAdded,
+			UserID:    1000,
+			Segment:   "AVITO_VOICE_MESSAGES",
+			CreatedAt: time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+}
+
+func TestDeliverSuccess(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []Payload
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+		}
+		if !Verify("secret", body, r.Header.Get(HeaderSignature)) {
+			t.Errorf("invalid signature %q", r.Header.Get(HeaderSignature))
+		}
+		if got := r.Header.Get(HeaderEventID); got != strconv.Itoa(42) {
+			t.Errorf("event id header = %q, want 42", got)
+		}
+		var payload Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+		mu.Lock()
+		received = append(received, payload)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	store := &fakeStore{}
+	newTestDispatcher(store).deliver(context.Background(), testDelivery(srv.URL, 0))
+
+	if len(received) != 1 {
+		t.Fatalf("server received %d requests, want 1", len(received))
+	}
+	want := Payload{ID: 42, Tenant: "default", Type: storage.EventSegmentAdded, UserID: 1000, Segment: "AVITO_VOICE_MESSAGES",
+		CreatedAt: time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)}
+	if received[0] != want {
+		t.Errorf("payload = %+v, want %+v", received[0], want)
+	}
+	if len(store.delivered) != 1 || store.delivered[0] != 7 {
+		t.Errorf("delivered = %v, want [7]", store.delivered)
+	}
+	if len(store.failed) != 0 {
+		t.Errorf("failed = %v, want none", store.failed)
+	}
+}
+
+func TestDeliverFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	tests := []struct {
+		name        string
+		url         string
+		attempts    int
+		wantErr     string
+		wantBackoff time.Duration
+	}{
+		{name: "first attempt", url: srv.URL, attempts: 0, wantErr: "unexpected status 500", wantBackoff: time.Second},
+		{name: "retry", url: srv.URL, attempts: 1, wantErr: "unexpected status 500", wantBackoff: 2 * time.Second},
+		// The store moves the delivery to dead letters once attempts reach maxAttempts.
+		{name: "last attempt", url: srv.URL, attempts: 2, wantErr: "unexpected status 500", wantBackoff: 4 * time.Second},
+		{name: "unreachable", url: closed.URL, attempts: 0, wantBackoff: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeStore{}
+			newTestDispatcher(store).deliver(context.Background(), testDelivery(tt.url, tt.attempts))
+
+			if len(store.delivered) != 0 {
+				t.Errorf("delivered = %v, want none", store.delivered)
+			}
+			if len(store.failed) != 1 {
+				t.Fatalf("failed %d times, want 1", len(store.failed))
+			}
+			got := store.failed[0]
+			if got.deliveryId != 7 || got.maxAttempts != 3 || got.backoff != tt.wantBackoff {
+				t.Errorf("failure = %+v, want delivery 7, max attempts 3, backoff %s", got, tt.wantBackoff)
+			}
+			if tt.wantErr != "" && got.err != tt.wantErr {
+				t.Errorf("error = %q, want %q", got.err, tt.wantErr)
+			}
+			if got.err == "" {
+				t.Error("error is empty")
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := newTestDispatcher(&fakeStore{})
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: time.Second},
+		{attempt: 2, want: 2 * time.Second},
+		{attempt: 3, want: 4 * time.Second},
+		{attempt: 4, want: 8 * time.Second},
+		{attempt: 5, want: 10 * time.Second},
+		{attempt: 100, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := d.backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const (
+	HeaderSignature = "X-Webhook-Signature"
+	HeaderEventID   = "X-Webhook-Event-Id"
+
+	signaturePrefix = "sha256="
+)
+
+// Sign returns the value of the signature header for body. Receivers
+// recompute it with the shared secret to authenticate the request.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
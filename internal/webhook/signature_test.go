@@ -0,0 +1,39 @@
+package webhook
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"id":1,"type":"segment.added"}`)
+	signature := Sign("secret", body)
+
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		t.Fatalf("signature %q has no %q prefix", signature, signaturePrefix)
+	}
+	if Sign("secret", body) != signature {
+		t.Fatal("signature is not deterministic")
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{name: "valid", secret: "secret", body: body, signature: signature, want: true},
+		{name: "wrong secret", secret: "other", body: body, signature: signature, want: false},
+		{name: "tampered body", secret: "secret", body: []byte(`{"id":2,"type":"segment.added"}`), signature: signature, want: false},
+		{name: "without prefix", secret: "secret", body: body, signature: strings.TrimPrefix(signature, signaturePrefix), want: false},
+		{name: "empty signature", secret: "secret", body: body, signature: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.secret, tt.body, tt.signature); got != tt.want {
+				t.Errorf("Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}